@@ -0,0 +1,155 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Exit codes of grout audit
+const (
+	auditExitViolations = 1
+	auditExitError      = 2
+)
+
+var (
+	auditDirs   []string
+	auditFormat string
+	policyFile  string
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Report git remotes that break a url policy",
+	Long: `
+Report git remotes that break a url policy:
+
+  Search the same directories as plan and check every remote url against
+  a policy file: deprecated hosts, plain http, disallowed orgs, passwords
+  or tokens embedded in urls, and hosts that aren't allowed. Nothing is
+  modified.
+
+  Exits 0 if every url follows the policy, 1 if any url breaks it, and 2
+  if the policy can't be read or repos couldn't be searched. Repos that
+  can't be read are reported as errors; they exit 2 if no url breaks the
+  policy, as the audit is incomplete.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := loadPolicy(policyFile)
+		if err != nil {
+			fmt.Printf("Unable to read policy: %s\n", err)
+			os.Exit(auditExitError)
+		}
+		if cmd.Flags().Changed("directory") {
+			targetDirs = auditDirs
+		}
+		if err := prepareSearch(cmd.Flags().Changed("directory")); err != nil {
+			fmt.Println(err)
+			os.Exit(auditExitError)
+		}
+
+		violations, code, err := auditRepositories(cmd.Context(), policy)
+		if err != nil {
+			log.Println(err)
+			os.Exit(auditExitError)
+		}
+
+		switch auditFormat {
+		case outputText:
+			DisplayViolations(violations)
+			if errorBundle.Count > 0 {
+				fmt.Println("Some repos could not be read, so the audit may be incomplete:")
+				DisplayBundledErrors()
+			}
+		case outputJSON:
+			// a clean audit is an empty list, not null
+			if violations == nil {
+				violations = []grout.Violation{}
+			}
+			jsonStr, err := json.MarshalIndent(violations, "", twoSpaces)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(auditExitError)
+			}
+			fmt.Println(string(jsonStr))
+			// keep stdout valid json
+			for _, err := range errorBundle.Errors {
+				fmt.Fprintln(os.Stderr, grout.RedactURL(err.Error()))
+			}
+		default:
+			fmt.Printf("Unknown output format: %s\n", auditFormat)
+			os.Exit(auditExitError)
+		}
+
+		if code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// Search for repos and check their remotes against a policy, returning the
+// violations and the code audit exits with. Violations are reported with
+// exit 1 even if some repos couldn't be read; with none, unreadable repos
+// exit 2 as the audit is incomplete. The error is only set if the search
+// itself failed
+func auditRepositories(ctx context.Context, policy grout.Policy) ([]grout.Violation, int, error) {
+	repoMap, err := searchForRepositories(ctx, nil)
+	if err != nil {
+		return nil, auditExitError, err
+	}
+	violations := policy.Audit(repoMap)
+	switch {
+	case len(violations) > 0:
+		return violations, auditExitViolations, nil
+	case errorBundle.Count > 0:
+		return violations, auditExitError, nil
+	}
+	return violations, 0, nil
+}
+
+// Read a policy from a yaml, json or toml file. Unknown keys are an error,
+// so a misspelt rule isn't silently ignored
+func loadPolicy(filename string) (grout.Policy, error) {
+	var policy grout.Policy
+	if len(filename) == 0 {
+		return policy, fmt.Errorf("no policy file given, set one with --policy")
+	}
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil {
+		return policy, err
+	}
+	if err := v.UnmarshalExact(&policy); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().StringVar(&policyFile, "policy", "", "Policy file (yaml, json or toml) to check remote urls against")
+	auditCmd.Flags().StringArrayVarP(&auditDirs, "directory", "d", nil, "Set search directory (may be repeated)")
+	auditCmd.Flags().StringVar(&reposFrom, "repos-from", "", "Read repo paths from a file, one per line ('-' for stdin), instead of searching")
+	auditCmd.Flags().StringVarP(&auditFormat, "output", "o", outputText, "Output format: text or json")
+}
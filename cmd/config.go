@@ -0,0 +1,322 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Configuration keys. These double as flag names, profile keys and, with a
+// GROUT_ prefix and underscores, environment variable names
+const (
+	keyProfile         = "profile"
+	keyProfiles        = "profiles"
+	keyFindURL         = "find-url"
+	keySetURL          = "set-url"
+	keyFindOrg         = "find-org"
+	keySetOrg          = "set-org"
+	keyOrgMap          = "org-map"
+	keyRemoteType      = "remote-type"
+	keyDirectories     = "directories"
+	keyExclude         = "exclude"
+	keyRules           = "rules"
+	keyRepos           = "repos"
+	keyBackend         = "backend"
+	keyBackupDir       = "backup-dir"
+	keyExcludeUnpushed = "exclude-unpushed"
+	keyStripCreds      = "strip-credentials"
+	keyGitSuffix       = "git-suffix"
+	keyNormalizeOnly   = "normalize-only"
+	keyUpgradeProtocol = "upgrade-protocol"
+	keyGitProtocolTo   = "git-protocol-to"
+	keySSHConfig       = "ssh-config"
+	keySSHAliases      = "ssh-aliases"
+
+	envPrefix = "grout"
+
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceProfile = "profile"
+	sourceConfig  = "config file"
+	sourceDefault = "default"
+
+	sshConfigNone     = "none"
+	sshAliasesKeep    = "keep"
+	sshAliasesReplace = "replace"
+)
+
+var profileName string
+var activeProfile *viper.Viper
+var orgMap map[string]string
+var excludePatterns []string
+var migrationRules []grout.Rule
+var backendName string
+var backend grout.Backend
+var backupDir string
+var noBackup bool
+var excludeUnpushed bool
+var stripCredentials bool
+var gitSuffix grout.GitSuffixPolicy
+var normalizeOnly bool
+var upgradeProtocol bool
+var gitProtocolTo string
+var sshConfigPath string
+var sshConfig *grout.SSHConfig
+var sshAliases string
+
+// Flags that have a configuration key, bound to viper so flag values take
+// precedence over the environment, profile and defaults
+var boundFlags = map[string]*pflag.Flag{}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect grout configuration",
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	Long: `
+Print the effective configuration:
+
+  Show the value grout will use for each setting and where it came from.
+  Values are taken from flags, then GROUT_* environment variables, then the
+  selected profile, then the top level of the config file, then grout.*
+  keys in git config (local, then global, then system), then defaults.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("Config file:  %s\n", valueOrNone(viper.ConfigFileUsed()))
+		fmt.Printf("Profile:      %s\n\n", valueOrNone(profileName))
+		DisplayEffectiveConfig(effectiveConfig())
+	},
+}
+
+// A setting as grout will use it, along with where its value came from
+type configValue struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+func valueOrNone(value string) string {
+	if len(value) == 0 {
+		return "(none)"
+	}
+	return value
+}
+
+// Register a flag's configuration key so that the flag value is preferred
+// over every other source
+func bindFlag(key string, flag *pflag.Flag) {
+	boundFlags[key] = flag
+	if err := viper.BindPFlag(key, flag); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Merge the selected profile over the top level of the config file. The
+// profile comes from --profile, GROUT_PROFILE or the config file's profile key
+func loadProfile() error {
+	if len(profileName) == 0 {
+		profileName = viper.GetString(keyProfile)
+	}
+	if len(profileName) == 0 {
+		return nil
+	}
+	activeProfile = viper.Sub(keyProfiles + "." + profileName)
+	if activeProfile == nil {
+		return fmt.Errorf("profile %q not found in config file", profileName)
+	}
+	return viper.MergeConfigMap(activeProfile.AllSettings())
+}
+
+// Copy the effective configuration into the package parameters used for planning
+func applyConfig() error {
+	targetRemoteURL = trimSlashSuffix(viper.GetString(keyFindURL))
+	newRemoteURL = trimSlashSuffix(viper.GetString(keySetURL))
+	targetOrganization = strings.ReplaceAll(viper.GetString(keyFindOrg), "/", "")
+	newOrganization = strings.ReplaceAll(viper.GetString(keySetOrg), "/", "")
+	remoteType = viper.GetString(keyRemoteType)
+	orgMap = viper.GetStringMapString(keyOrgMap)
+	excludePatterns = viper.GetStringSlice(keyExclude)
+	forcedRepos = viper.GetStringSlice(keyRepos)
+	excludeUnpushed = viper.GetBool(keyExcludeUnpushed)
+	stripCredentials = viper.GetBool(keyStripCreds)
+
+	backendName = viper.GetString(keyBackend)
+	var err error
+	if backend, err = grout.NewBackend(backendName); err != nil {
+		return err
+	}
+	if gitSuffix, err = grout.ParseGitSuffixPolicy(viper.GetString(keyGitSuffix)); err != nil {
+		return err
+	}
+	if normalizeOnly = viper.GetBool(keyNormalizeOnly); normalizeOnly && gitSuffix == grout.GitSuffixPreserve {
+		return fmt.Errorf("%s needs %s set to %s or %s", keyNormalizeOnly, keyGitSuffix, grout.GitSuffixAlways, grout.GitSuffixNever)
+	}
+	if upgradeProtocol = viper.GetBool(keyUpgradeProtocol); upgradeProtocol && normalizeOnly {
+		return fmt.Errorf("%s and %s can't be used together", keyUpgradeProtocol, keyNormalizeOnly)
+	}
+	if gitProtocolTo = strings.ToLower(viper.GetString(keyGitProtocolTo)); len(gitProtocolTo) == 0 {
+		gitProtocolTo = grout.ProtocolHTTPS
+	} else if gitProtocolTo != grout.ProtocolHTTPS && gitProtocolTo != grout.ProtocolSSH {
+		return fmt.Errorf("unknown %s %q, expected %s or %s", keyGitProtocolTo, gitProtocolTo, grout.ProtocolHTTPS, grout.ProtocolSSH)
+	}
+
+	if err := applySSHConfig(); err != nil {
+		return err
+	}
+
+	if backupDir = viper.GetString(keyBackupDir); len(backupDir) > 0 {
+		if backupDir, err = grout.NormalizePath(backupDir); err != nil {
+			return err
+		}
+	} else if backupDir, err = grout.DefaultBackupDir(); err != nil {
+		return err
+	}
+
+	if flag, ok := boundFlags[keyDirectories]; !ok || !flag.Changed {
+		if dirs := viper.GetStringSlice(keyDirectories); len(dirs) > 0 {
+			targetDirs = dirs
+		}
+	}
+
+	migrationRules = nil
+	if err := viper.UnmarshalKey(keyRules, &migrationRules); err != nil {
+		return fmt.Errorf("unable to read %s: %w", keyRules, err)
+	}
+	return nil
+}
+
+// Read the ssh config used to resolve host aliases, unless it is set to
+// none, and check what to do with the aliases
+func applySSHConfig() error {
+	sshConfig = nil
+	var err error
+	if sshConfigPath = viper.GetString(keySSHConfig); sshConfigPath != sshConfigNone {
+		if len(sshConfigPath) > 0 {
+			sshConfigPath, err = grout.NormalizePath(sshConfigPath)
+		} else {
+			sshConfigPath, err = grout.DefaultSSHConfigPath()
+		}
+		if err != nil {
+			return err
+		}
+		if sshConfig, err = grout.ReadSSHConfig(sshConfigPath); err != nil {
+			return fmt.Errorf("unable to read %s: %w", keySSHConfig, err)
+		}
+	}
+	switch sshAliases = strings.ToLower(viper.GetString(keySSHAliases)); sshAliases {
+	case "":
+		sshAliases = sshAliasesKeep
+	case sshAliasesKeep, sshAliasesReplace:
+	default:
+		return fmt.Errorf("unknown %s %q, expected %s or %s", keySSHAliases, sshAliases, sshAliasesKeep, sshAliasesReplace)
+	}
+	return nil
+}
+
+// Report where a configuration key's effective value comes from
+func configSource(key string) string {
+	if flag, ok := boundFlags[key]; ok && flag.Changed {
+		return sourceFlag
+	}
+	if _, ok := os.LookupEnv(envName(key)); ok {
+		return sourceEnv
+	}
+	if activeProfile != nil && activeProfile.IsSet(key) {
+		return fmt.Sprintf("%s %s", sourceProfile, profileName)
+	}
+	if viper.InConfig(key) {
+		return sourceConfig
+	}
+	if source, ok := gitConfigSources[key]; ok {
+		return source
+	}
+	return sourceDefault
+}
+
+func envName(key string) string {
+	return strings.ToUpper(envPrefix + "_" + strings.ReplaceAll(key, "-", "_"))
+}
+
+func effectiveConfig() []configValue {
+	var values []configValue
+	add := func(key, value string) {
+		values = append(values, configValue{Key: key, Value: value, Source: configSource(key)})
+	}
+
+	add(keyFindURL, targetRemoteURL)
+	add(keySetURL, newRemoteURL)
+	add(keyFindOrg, targetOrganization)
+	add(keySetOrg, newOrganization)
+	add(keyRemoteType, remoteType)
+	add(keyDirectories, strings.Join(targetDirs, ", "))
+	add(keyExclude, strings.Join(excludePatterns, ", "))
+	add(keyRepos, strings.Join(forcedRepos, ", "))
+	add(keyBackend, backendName)
+	add(keyBackupDir, backupDir)
+	add(keyExcludeUnpushed, fmt.Sprint(excludeUnpushed))
+	add(keyStripCreds, fmt.Sprint(stripCredentials))
+	add(keyGitSuffix, string(gitSuffix))
+	add(keyNormalizeOnly, fmt.Sprint(normalizeOnly))
+	add(keyUpgradeProtocol, fmt.Sprint(upgradeProtocol))
+	add(keyGitProtocolTo, gitProtocolTo)
+	add(keySSHConfig, sshConfigPath)
+	add(keySSHAliases, sshAliases)
+
+	var mappings []string
+	for from, to := range orgMap {
+		mappings = append(mappings, from+" -> "+to)
+	}
+	sort.Strings(mappings)
+	add(keyOrgMap, strings.Join(mappings, ", "))
+
+	var rules []string
+	for _, rule := range migrationRules {
+		rules = append(rules, rule.String())
+	}
+	add(keyRules, strings.Join(rules, "; "))
+	return values
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.PersistentFlags().StringVar(&profileName, keyProfile, "", "Named migration profile from the config file")
+	rootCmd.PersistentFlags().String(keyBackend, grout.BackendGoGit, "How git config is read and written: go-git, or git to run the git binary")
+	bindFlag(keyBackend, rootCmd.PersistentFlags().Lookup(keyBackend))
+	rootCmd.PersistentFlags().String(keyBackupDir, "", "Where configs are backed up before they are changed (default ~/.local/state/grout)")
+	bindFlag(keyBackupDir, rootCmd.PersistentFlags().Lookup(keyBackupDir))
+	rootCmd.PersistentFlags().BoolVar(&noBackup, "no-backup", false, "Don't back up configs before changing them")
+
+	viper.SetDefault(keyFindURL, defaultTargetHostname)
+	viper.SetDefault(keySetURL, defaultNewHostname)
+	viper.SetDefault(keyRemoteType, defaultRemoteType)
+	viper.SetDefault(keyBackend, grout.BackendGoGit)
+	viper.SetDefault(keyGitProtocolTo, grout.ProtocolHTTPS)
+	viper.SetDefault(keySSHAliases, sshAliasesKeep)
+}
@@ -6,6 +6,9 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"text/tabwriter"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
 )
 
 const (
@@ -21,7 +24,7 @@ func IAmGrout() {
 func DisplayBundledErrors() {
 	var output string
 	for i := 0; i < errorBundle.Count; i++ {
-		errorOut := fmt.Sprintf("\terror %d: %s\n", i+1, errorBundle.Errors[i])
+		errorOut := fmt.Sprintf("\terror %d: %s\n", i+1, grout.RedactURL(fmt.Sprint(errorBundle.Errors[i])))
 		output += errorOut
 	}
 	fmt.Println(output)
@@ -51,33 +54,48 @@ func DisplayBundledErrorsUpdate() {
 	}
 }
 
-func DisplayChangeCount(changes ChangeSet) {
+func DisplayChangeCount(changes grout.ChangeSet) {
 	fmt.Printf("\nPlanned %d change(s) across %d repo(s)\n\n", changes.Count, len(changes.Plans))
 }
 
-func DisplayChangeIntention(changes ChangeSet) {
+func DisplayChangeIntention(changes grout.ChangeSet) {
 	fmt.Printf("\nGRUT will perform %d change(s) across %d repo(s)\n\n", changes.Count, len(changes.Plans))
 }
 
-func DisplayChangeResult(changes ChangeSet) {
+func DisplayChangeResult(changes grout.ChangeSet) {
 	fmt.Printf("\nCompleted %d change(s) across %d repo(s)\n\n", changes.Count, len(changes.Plans))
 }
 
-func DisplayChangePlanForDirectory(plan RepoPlan) {
+func DisplayChangePlanForDirectory(plan grout.RepoPlan) {
 	localRepo := plan.Repo
 
 	var sb strings.Builder
 	output := fmt.Sprintf(""+
-		"%sRepository:   %s\n"+
+		"%sRepository:   %s%s\n"+
 		"%sPath:\t\t%s",
-		twoSpaces, localRepo.Name,
+		twoSpaces, localRepo.Name, excludedLabel(plan.Excluded),
 		twoSpaces, localRepo.Path)
 	sb.WriteString(output)
+	if len(localRepo.RemoteSlug) > 0 {
+		sb.WriteString(fmt.Sprintf("\n%sRemote repo:\t%s", twoSpaces, localRepo.RemoteSlug))
+	}
+	if len(localRepo.GivenPath) > 0 {
+		sb.WriteString(fmt.Sprintf("\n%sGiven as:\t%s", twoSpaces, localRepo.GivenPath))
+	}
+	if len(localRepo.CanonicalPath) > 0 && localRepo.CanonicalPath != localRepo.Path {
+		sb.WriteString(fmt.Sprintf("\n%sCanonical:\t%s", twoSpaces, localRepo.CanonicalPath))
+	}
+	if plan.UnpushedWork != nil {
+		sb.WriteString(fmt.Sprintf("\n%sUnpushed:\t%s", twoSpaces, plan.UnpushedWork))
+	}
 	fmt.Println(sb.String())
 	for _, change := range plan.Changes {
-		fmt.Printf("%sRemote: \t%s\n", sixSpaces, change.Name)
+		fmt.Printf("%sRemote: \t%s%s\n", sixSpaces, change.Name, excludedLabel(change.Excluded))
 		for i := 0; i < len(change.NewURLs); i++ {
-			fmt.Printf("%s  Change:       %s -> %s\n", sixSpaces, change.CurrentURLs[i], change.NewURLs[i])
+			fmt.Printf("%s  Change:       %s -> %s\n", sixSpaces, grout.RedactURL(change.CurrentURLs[i]), grout.RedactURL(change.NewURLs[i]))
+		}
+		if change.SSHAlias != nil {
+			fmt.Printf("%s  SSH alias:    %s\n", sixSpaces, change.SSHAlias)
 		}
 	}
 	fmt.Println()
@@ -98,14 +116,20 @@ func ParametersConfirmationOutput() string {
 			"    New URL:               %s\n"+
 			"    Target Organization:   %s\n"+
 			"    New Organization:      %s\n"+
+			"    .git Suffix:           %s\n"+
+			"    Mode:                  %s\n"+
+			"    SSH Aliases:           %s\n"+
 			"\nEnter '%s' to confirm parameters and create a plan: ",
-		targetDir, targetRemoteURL, newRemoteURL, targetOrgVal, newOrgVal, Yes)
+		strings.Join(targetDirs, ", "), targetRemoteURL, newRemoteURL, targetOrgVal, newOrgVal, gitSuffix, planModeLabel(),
+		sshAliasesLabel(), Yes)
 	return confirmation
 }
 
+// Shared across prompts so that buffered input isn't lost between calls
+var reader = bufio.NewReader(os.Stdin)
+
 func promptForInput(prompt string, dflt string) string {
 	var reply string
-	reader := bufio.NewReader(os.Stdin)
 	fmt.Print(prompt)
 
 	if runtime.GOOS == Windows {
@@ -122,3 +146,328 @@ func promptForInput(prompt string, dflt string) string {
 
 	return reply
 }
+
+func DisplayChangeSetMarkdown(changes grout.ChangeSet) {
+	var sb strings.Builder
+	sb.WriteString("# grout plan\n\n")
+	sb.WriteString(fmt.Sprintf("%d change(s) across %d repo(s)\n", changes.Count, len(changes.Plans)))
+	for _, plan := range changes.Plans {
+		sb.WriteString(fmt.Sprintf("\n## %s%s\n\n", plan.Repo.Name, excludedLabel(plan.Excluded)))
+		sb.WriteString(fmt.Sprintf("Path: `%s`\n\n", plan.Repo.Path))
+		if len(plan.Repo.GivenPath) > 0 {
+			sb.WriteString(fmt.Sprintf("Given as: `%s`\n\n", plan.Repo.GivenPath))
+		}
+		if len(plan.Repo.RemoteSlug) > 0 {
+			sb.WriteString(fmt.Sprintf("Remote repo: `%s`\n\n", plan.Repo.RemoteSlug))
+		}
+		if plan.UnpushedWork != nil {
+			sb.WriteString(fmt.Sprintf("Unpushed work: %s\n\n", plan.UnpushedWork))
+		}
+		sb.WriteString("| Remote | Current URL | New URL |\n")
+		sb.WriteString("|--------|-------------|---------|\n")
+		for _, change := range plan.Changes {
+			for i := 0; i < len(change.NewURLs) && i < len(change.CurrentURLs); i++ {
+				sb.WriteString(fmt.Sprintf("| %s%s | `%s`%s | `%s` |\n",
+					change.Name, excludedLabel(change.Excluded), grout.RedactURL(change.CurrentURLs[i]), sshAliasLabel(change),
+					grout.RedactURL(change.NewURLs[i])))
+			}
+		}
+	}
+	if len(changes.Broken) > 0 {
+		sb.WriteString("\n## Broken remotes\n\n")
+		sb.WriteString("| Repo | Remote | URL | Reason |\n")
+		sb.WriteString("|------|--------|-----|--------|\n")
+		for _, remote := range changes.Broken {
+			sb.WriteString(fmt.Sprintf("| %s | %s | `%s` | %s |\n", remote.Name, remote.Remote, remote.URL, remote.Reason))
+		}
+	}
+	if len(changes.Skipped) > 0 {
+		sb.WriteString("\n## Skipped repos\n\n")
+		sb.WriteString("| Repo | Path | Reason |\n")
+		sb.WriteString("|------|------|--------|\n")
+		for _, repo := range changes.Skipped {
+			sb.WriteString(fmt.Sprintf("| %s | `%s` | %s |\n", repo.Name, repo.Path, repo.Reason))
+		}
+	}
+	fmt.Print(sb.String())
+}
+
+func DisplayValidationErrors(filename string, errs []error) {
+	if len(errs) == 0 {
+		fmt.Printf("%s is valid\n", filename)
+		return
+	}
+	fmt.Printf("%s has %d problem(s):\n", filename, len(errs))
+	for i, err := range errs {
+		fmt.Printf("\terror %d: %s\n", i+1, grout.RedactURL(err.Error()))
+	}
+}
+
+func planModeLabel() string {
+	switch {
+	case normalizeOnly:
+		return "normalize .git suffixes only, no urls are moved"
+	case upgradeProtocol:
+		return fmt.Sprintf("upgrade protocols only (http -> https, git:// -> %s) on any host", gitProtocolTo)
+	}
+	return "move urls from the target url to the new url"
+}
+
+func sshAliasesLabel() string {
+	if sshConfig == nil {
+		return "not resolved"
+	}
+	return fmt.Sprintf("%s (%s)", sshAliases, sshConfigPath)
+}
+
+func sshAliasLabel(change grout.RemoteChange) string {
+	if change.SSHAlias == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (ssh alias %s)", change.SSHAlias)
+}
+
+func excludedLabel(excluded bool) string {
+	if excluded {
+		return " (excluded)"
+	}
+	return ""
+}
+
+// Print a numbered checklist of the repos and changes in a plan. The
+// returned items map each number (index + 1) to the entry it toggles
+func DisplayChecklist(changes grout.ChangeSet) []checklistItem {
+	var items []checklistItem
+	for i, plan := range changes.Plans {
+		items = append(items, checklistItem{Plan: i, Change: -1})
+		fmt.Printf("%s%s %3d  %s  %s\n", twoSpaces, checkbox(!plan.Excluded), len(items), plan.Repo.Name, plan.Repo.Path)
+		for j, change := range plan.Changes {
+			items = append(items, checklistItem{Plan: i, Change: j})
+			for k := 0; k < len(change.NewURLs) && k < len(change.CurrentURLs); k++ {
+				fmt.Printf("%s    %s %3d  %s: %s -> %s\n", twoSpaces, checkbox(!change.Excluded), len(items),
+					change.Name, grout.RedactURL(change.CurrentURLs[k]), grout.RedactURL(change.NewURLs[k]))
+			}
+		}
+	}
+	fmt.Printf("\n%d change(s) selected\n", changes.Count)
+	return items
+}
+
+func checkbox(checked bool) string {
+	if checked {
+		return "[x]"
+	}
+	return "[ ]"
+}
+
+func DisplayEffectiveConfig(values []configValue) {
+	for _, value := range values {
+		fmt.Printf("%s%-18s %-40s (%s)\n", twoSpaces, value.Key+":", valueOrNone(value.Value), value.Source)
+	}
+}
+
+func DisplaySkippedRepositories(skipped []grout.SkippedRepository) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Printf("Skipped %d repo(s):\n", len(skipped))
+	for _, repo := range skipped {
+		fmt.Printf("%sRepository:   %s\n", twoSpaces, repo.Name)
+		fmt.Printf("%sPath:\t\t%s\n", twoSpaces, repo.Path)
+		fmt.Printf("%sReason:\t%s\n", twoSpaces, repo.Reason)
+	}
+	fmt.Println()
+}
+
+func DisplayBackupRuns(store grout.BackupStore, runs []*grout.BackupRun) {
+	if len(runs) == 0 {
+		fmt.Printf("No backup runs found in %s\n", store.Dir)
+		return
+	}
+	fmt.Printf("Backup runs in %s:\n", store.Dir)
+	for _, run := range runs {
+		fmt.Printf("%s%-22s %s  %d repo(s)\n", twoSpaces, run.ID, run.Started.Local().Format("2006-01-02 15:04:05"), len(run.Entries))
+	}
+}
+
+func DisplayBackupEntries(run *grout.BackupRun, entries []grout.BackupEntry) {
+	fmt.Printf("Run %s backed up %d repo(s):\n", run.ID, len(entries))
+	for _, entry := range entries {
+		fmt.Printf("%sRepository:   %s\n", twoSpaces, entry.Name)
+		fmt.Printf("%sPath:\t\t%s\n", twoSpaces, entry.Path)
+	}
+}
+
+func DisplayRestoreResult(entry grout.BackupEntry, err error) {
+	if err != nil {
+		fmt.Printf("%sNot restored: %s%s\n", twoSpaces, err, restoreErrorHint(err))
+		return
+	}
+	fmt.Printf("%sRestored:     %s (%s)\n", twoSpaces, entry.Name, entry.Path)
+}
+
+func DisplayLockedRepositories(paths []string) {
+	fmt.Printf("%d repo(s) were left unchanged because another process held their config.lock:\n", len(paths))
+	for _, path := range paths {
+		fmt.Printf("%sPath:\t\t%s\n", twoSpaces, path)
+	}
+	fmt.Println("Run the update again once the other process has finished.")
+}
+
+func DisplayURLVerification(result grout.URLVerification) {
+	fmt.Printf("%s%-13s %s  %s: %s\n", twoSpaces, "["+result.Status+"]", result.Repo, result.Remote, result.URL)
+	if len(result.Error) > 0 {
+		fmt.Printf("%s%s\n", sixSpaces, result.Error)
+	}
+	for _, mismatch := range result.Mismatches {
+		remote := mismatch.Remote
+		if len(remote) == 0 {
+			remote = "missing"
+		}
+		fmt.Printf("%sBranch %s: local %s, new remote %s\n", sixSpaces, mismatch.Branch, mismatch.Local, remote)
+	}
+}
+
+func DisplayVerificationSummary(results []grout.URLVerification) {
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	fmt.Printf("\nVerified %d url(s): %d ok, %d ref mismatch(es), %d missing, %d auth failure(s), %d unreachable\n\n",
+		len(results), counts[grout.VerifyOK], counts[grout.VerifyMismatch], counts[grout.VerifyMissing],
+		counts[grout.VerifyAuthFailed], counts[grout.VerifyUnreachable])
+}
+
+func DisplayUnpushedWork(changes grout.ChangeSet) {
+	count, excluded := 0, 0
+	var sb strings.Builder
+	for _, plan := range changes.Plans {
+		if plan.UnpushedWork == nil {
+			continue
+		}
+		count++
+		if plan.Excluded {
+			excluded++
+		}
+		sb.WriteString(fmt.Sprintf("%sRepository:   %s%s\n", twoSpaces, plan.Repo.Name, excludedLabel(plan.Excluded)))
+		sb.WriteString(fmt.Sprintf("%sPath:\t\t%s\n", twoSpaces, plan.Repo.Path))
+		sb.WriteString(fmt.Sprintf("%sUnpushed:\t%s\n", twoSpaces, plan.UnpushedWork))
+	}
+	if count == 0 {
+		return
+	}
+	fmt.Printf("WARNING: %d repo(s) have work that hasn't been pushed to the remotes being changed:\n", count)
+	fmt.Print(sb.String())
+	if excluded < count {
+		fmt.Println("Push it before updating, or plan again with --exclude-unpushed to leave these repos out.")
+	} else {
+		fmt.Println("These repos were excluded. Push the work and plan again, or re-include them with plan edit --include-repo.")
+	}
+	fmt.Println()
+}
+
+func DisplayFetchResult(result grout.FetchResult) {
+	if len(result.Error) > 0 {
+		fmt.Printf("%s[failed]  %s  %s: %s\n", twoSpaces, result.Repo.Name, result.Remote, result.Error)
+		return
+	}
+	head := result.Head
+	if len(head) == 0 {
+		head = "unchanged"
+	}
+	fmt.Printf("%s[fetched] %s  %s (HEAD: %s)\n", twoSpaces, result.Repo.Name, result.Remote, head)
+	for _, branch := range result.MissingUpstreams {
+		fmt.Printf("%sBranch %s tracks a branch that doesn't exist on the new host\n", sixSpaces, branch)
+	}
+}
+
+func DisplayFetchSummary(results []grout.FetchResult) {
+	failed, missing := 0, 0
+	for _, result := range results {
+		if len(result.Error) > 0 {
+			failed++
+		}
+		missing += len(result.MissingUpstreams)
+	}
+	fmt.Printf("\nFetched %d of %d remote(s), %d branch(es) with a missing upstream\n\n", len(results)-failed, len(results), missing)
+}
+
+func DisplayInventory(inventory grout.Inventory) {
+	if len(inventory.Entries) == 0 {
+		fmt.Println("No git remotes found.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tORG\tREMOTES\tREPOS")
+	for _, host := range inventory.Hosts {
+		fmt.Fprintf(w, "%s\t\t%d\t%d\n", inventoryLabel(host.Host), host.Remotes, host.Repos)
+		for _, org := range host.Orgs {
+			fmt.Fprintf(w, "\t%s\t%d\t%d\n", inventoryLabel(org.Org), org.Remotes, org.Repos)
+		}
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tREMOTE\tPROTOCOL\tHOST\tORG\tNAME\tURL")
+	for _, e := range inventory.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Repo, e.Remote, e.Protocol,
+			inventoryLabel(e.Host), inventoryLabel(e.Org), inventoryLabel(e.RepoName), e.URL)
+	}
+	w.Flush()
+	fmt.Printf("\n%d remote url(s) in %d repo(s) across %d host(s)\n", len(inventory.Entries), countInventoryRepos(inventory), len(inventory.Hosts))
+	DisplayDuplicateRemotes(inventory.Duplicates)
+}
+
+func DisplayDuplicateRemotes(duplicates []grout.DuplicateRemotes) {
+	if len(duplicates) == 0 {
+		return
+	}
+	fmt.Printf("\n%d repo(s) have several remotes for the same repo:\n", len(duplicates))
+	for _, dup := range duplicates {
+		fmt.Printf("%sRepository:   %s\n", twoSpaces, dup.Repo)
+		fmt.Printf("%sPath:\t\t%s\n", twoSpaces, dup.Path)
+		fmt.Printf("%sCanonical:\t%s\n", twoSpaces, dup.Canonical)
+		fmt.Printf("%sRemotes:\t%s\n", twoSpaces, strings.Join(dup.Remotes, ", "))
+		for _, url := range dup.URLs {
+			fmt.Printf("%s  Url:          %s\n", sixSpaces, url)
+		}
+	}
+}
+
+// Urls grout can't parse have no host, org or name
+func inventoryLabel(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+func DisplayViolations(violations []grout.Violation) {
+	if len(violations) == 0 {
+		fmt.Println("No policy violations found.")
+		return
+	}
+	for _, violation := range violations {
+		fmt.Printf("%s%-22s %s  %s: %s\n", twoSpaces, "["+violation.Rule+"]", violation.Repo, violation.Remote, violation.URL)
+		fmt.Printf("%s%s\n", sixSpaces, violation.Message)
+	}
+	fmt.Printf("\nFound %d policy violation(s)\n\n", len(violations))
+}
+
+func DisplayBrokenRemotes(broken []grout.BrokenRemote) {
+	if len(broken) == 0 {
+		return
+	}
+	fmt.Printf("%d remote url(s) are broken:\n", len(broken))
+	for _, remote := range broken {
+		fmt.Printf("%sRepository:   %s\n", twoSpaces, remote.Name)
+		fmt.Printf("%sPath:\t\t%s\n", twoSpaces, remote.Path)
+		fmt.Printf("%sRemote:\t%s: %s\n", twoSpaces, remote.Remote, remote.URL)
+		fmt.Printf("%sReason:\t%s\n", twoSpaces, remote.Reason)
+	}
+	if !upgradeProtocol {
+		fmt.Println("Plan with --upgrade-protocol to move git:// urls to https, or ssh with --git-protocol-to ssh.")
+	}
+	fmt.Println()
+}
@@ -0,0 +1,177 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+)
+
+const (
+	gitConfigSection = grout.ConfigSection
+
+	scopeSystem = "system"
+	scopeGlobal = "global"
+	scopeLocal  = "local"
+)
+
+// Where each configuration key read from git config came from
+var gitConfigSources = map[string]string{}
+
+// A git config file and the scope it was read for
+type gitConfigFile struct {
+	Scope string
+	Path  string
+}
+
+// grout.* option names, with dashes removed and lower cased as git does,
+// mapped to the configuration key they set. grout.hostname is kept as an
+// alias of find-url
+var gitConfigKeys = map[string]string{
+	"hostname":         keyFindURL,
+	"findurl":          keyFindURL,
+	"seturl":           keySetURL,
+	"findorg":          keyFindOrg,
+	"setorg":           keySetOrg,
+	"remotetype":       keyRemoteType,
+	"orgmap":           keyOrgMap,
+	"directory":        keyDirectories,
+	"directories":      keyDirectories,
+	"exclude":          keyExclude,
+	"repo":             keyRepos,
+	"backend":          keyBackend,
+	"backupdir":        keyBackupDir,
+	"excludeunpushed":  keyExcludeUnpushed,
+	"stripcredentials": keyStripCreds,
+	"gitsuffix":        keyGitSuffix,
+	"normalizeonly":    keyNormalizeOnly,
+	"upgradeprotocol":  keyUpgradeProtocol,
+	"gitprotocolto":    keyGitProtocolTo,
+	"sshconfig":        keySSHConfig,
+	"sshaliases":       keySSHAliases,
+}
+
+// Keys that may be given more than once. Their values accumulate across
+// scopes rather than overriding each other
+var gitConfigListKeys = map[string]bool{
+	keyOrgMap:      true,
+	keyDirectories: true,
+	keyExclude:     true,
+	keyRepos:       true,
+}
+
+// List the git config files that apply to the working directory, lowest
+// precedence first, following git's GIT_CONFIG_* overrides
+func gitConfigFiles(workDir string) []gitConfigFile {
+	var files []gitConfigFile
+
+	if _, ok := os.LookupEnv("GIT_CONFIG_NOSYSTEM"); !ok {
+		system := "/etc/gitconfig"
+		if path, ok := os.LookupEnv("GIT_CONFIG_SYSTEM"); ok {
+			system = path
+		}
+		files = append(files, gitConfigFile{Scope: scopeSystem, Path: system})
+	}
+
+	if path, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+		files = append(files, gitConfigFile{Scope: scopeGlobal, Path: path})
+	} else {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		home, err := homedir.Dir()
+		if len(xdg) == 0 && err == nil {
+			xdg = filepath.Join(home, ".config")
+		}
+		if len(xdg) > 0 {
+			files = append(files, gitConfigFile{Scope: scopeGlobal, Path: filepath.Join(xdg, "git", "config")})
+		}
+		if err == nil {
+			files = append(files, gitConfigFile{Scope: scopeGlobal, Path: filepath.Join(home, ".gitconfig")})
+		}
+	}
+
+	if gitDir := grout.FindGitDir(workDir); len(gitDir) > 0 {
+		files = append(files, gitConfigFile{Scope: scopeLocal, Path: filepath.Join(gitDir, "config")})
+	}
+	return files
+}
+
+// Collect the grout section from each git config file. Returns the values
+// by configuration key and a description of where each one came from
+func readGitConfigSettings(files []gitConfigFile) (map[string]interface{}, map[string]string, error) {
+	settings := map[string]interface{}{}
+	sources := map[string]string{}
+	lists := map[string][]string{}
+
+	for _, file := range files {
+		cfg, err := grout.ReadGitConfigFile(file.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, option := range cfg.Section(gitConfigSection).Options {
+			key, ok := gitConfigKeys[strings.ToLower(strings.ReplaceAll(option.Key, "-", ""))]
+			if !ok {
+				continue
+			}
+			sources[key] = fmt.Sprintf("git config %s %s", file.Scope, file.Path)
+			if gitConfigListKeys[key] {
+				lists[key] = append(lists[key], option.Value)
+			} else {
+				settings[key] = option.Value
+			}
+		}
+	}
+
+	for key, values := range lists {
+		if key != keyOrgMap {
+			settings[key] = values
+			continue
+		}
+		mappings := map[string]string{}
+		for _, value := range values {
+			from, to, ok := strings.Cut(value, "=")
+			if !ok {
+				return nil, nil, fmt.Errorf("%s.orgmap %q should be in the form old=new", gitConfigSection, value)
+			}
+			mappings[strings.TrimSpace(from)] = strings.TrimSpace(to)
+		}
+		settings[key] = mappings
+	}
+	return settings, sources, nil
+}
+
+// Use grout.* settings from git config as defaults, so that the config
+// file, environment and flags can all override them
+func loadGitConfigDefaults() error {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	settings, sources, err := readGitConfigSettings(gitConfigFiles(workDir))
+	if err != nil {
+		return err
+	}
+	for key, value := range settings {
+		viper.SetDefault(key, value)
+	}
+	gitConfigSources = sources
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputTable  = "table"
+	outputCSV    = "csv"
+	outputNDJSON = "ndjson"
+)
+
+var (
+	inventoryDirs       []string
+	inventoryFormat     string
+	inventoryDuplicates bool
+)
+
+// inventoryCmd represents the inventory command
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "List every git remote found, counted by host and org",
+	Long: `
+List every git remote found, counted by host and org:
+
+  Search the same directories as plan and list each remote url of each
+  repo with its protocol, host, org and repo name. The table output counts
+  remotes and repos by host and org; json, csv and ndjson list every url
+  for other tools. No rules are applied and nothing is modified.
+
+  Urls are also reduced to a canonical host/org/repo, ignoring protocol,
+  case, default ports, .git suffixes and trailing slashes. Repos with
+  several remotes pointing at the same canonical repo are reported, and
+  --duplicates lists only those remotes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("directory") {
+			targetDirs = inventoryDirs
+		}
+		if err := prepareSearch(cmd.Flags().Changed("directory")); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		repoMap, err := searchForRepositories(cmd.Context(), nil)
+		if err != nil {
+			log.Println(err)
+		}
+		inventory := grout.BuildInventory(repoMap)
+		if inventoryDuplicates {
+			inventory = inventory.OnlyDuplicates()
+		}
+
+		switch inventoryFormat {
+		case outputTable:
+			DisplayInventory(inventory)
+			DisplayBundledErrorsPlan()
+		case outputJSON:
+			jsonStr, err := json.MarshalIndent(inventory, "", twoSpaces)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonStr))
+		case outputCSV:
+			err = writeInventoryCSV(os.Stdout, inventory)
+		case outputNDJSON:
+			err = writeInventoryNDJSON(os.Stdout, inventory)
+		default:
+			fmt.Printf("Unknown output format: %s\n", inventoryFormat)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// Write one row per remote url, with a header row
+func writeInventoryCSV(w io.Writer, inventory grout.Inventory) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"repo", "path", "remote", "url", "protocol", "host", "org", "repo_name", "canonical"})
+	for _, e := range inventory.Entries {
+		out.Write([]string{e.Repo, e.Path, e.Remote, e.URL, e.Protocol, e.Host, e.Org, e.RepoName, e.Canonical})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Write one JSON object per remote url, one per line
+func writeInventoryNDJSON(w io.Writer, inventory grout.Inventory) error {
+	enc := json.NewEncoder(w)
+	for _, entry := range inventory.Entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+	inventoryCmd.Flags().StringArrayVarP(&inventoryDirs, "directory", "d", nil, "Set search directory (may be repeated)")
+	inventoryCmd.Flags().StringVar(&reposFrom, "repos-from", "", "Read repo paths from a file, one per line ('-' for stdin), instead of searching")
+	inventoryCmd.Flags().StringVarP(&inventoryFormat, "output", "o", outputTable, "Output format: table, json, csv or ndjson")
+	inventoryCmd.Flags().BoolVar(&inventoryDuplicates, "duplicates", false, "Only list remotes that point at the same repo as another remote")
+}
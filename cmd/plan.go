@@ -19,12 +19,14 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/JoshRodstein/grout/pkg/grout"
 	"github.com/spf13/cobra"
 )
 
+var assumeYes bool
+
 // planCmd represents the plan command
 var planCmd = &cobra.Command{
 	Use:   "plan",
@@ -34,38 +36,44 @@ Generate a plan for updating git remotes:
 
   Search all folders under given directory for git repositories and 
   Spit out a plan for updating git remotes to a new URL. Plan is saved 
-  as test-grout-plan.json unless otherwise specified.`,
+  as test-grout-plan.json unless otherwise specified.
+
+  Search directories may be relative, start with ~ or contain $VARS.
+  --directory may be given more than once to search several directories.
+  --repos-from reads an exact list of repo paths instead of searching;
+  repos found more than once are only planned once.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := verifyTargetDirIsAbs(); err != nil {
+		if err := prepareSearch(cmd.Flags().Changed("directory")); err != nil {
+			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		fmt.Println()
-		fmt.Println("Plan parameters:")
-		confirmation := ParametersConfirmationOutput()
-		input := promptForInput(confirmation, "")
-		fmt.Println()
-		if strings.Compare(strings.ToLower(input), Yes) != 0 {
-			fmt.Println("Aborting changes")
-			os.Exit(0)
+		// stdin can't answer the prompt once it has been read as a repo list
+		if !assumeYes && reposFrom != "-" {
+			fmt.Println()
+			fmt.Println("Plan parameters:")
+			confirmation := ParametersConfirmationOutput()
+			input := promptForInput(confirmation, "")
+			fmt.Println()
+			if strings.Compare(strings.ToLower(input), Yes) != 0 {
+				fmt.Println("Aborting changes")
+				os.Exit(0)
+			}
 		}
 
 		fmt.Println("Generating plan...")
 
 		// Walk directory tree and map repositories
-		err := filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
-			err = mapRepository(path, info, err, &repoMap)
-			if err != nil {
-				return err
-			}
-			return nil
-		})
+		repoMap, err := searchForRepositories(cmd.Context(), nil)
 		if err != nil {
 			log.Println(err)
 		}
 
-		changeSet = createChangeSetFromMap(repoMap)
-		writeChangeSetToFile(changeSet, defaultPlanFile)
+		changeSet := createChangeSetFromMap(cmd.Context(), repoMap)
+		if err := writeChangeSetToFile(changeSet, defaultPlanFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
 		if changeSet.Count > 0 {
 			fmt.Printf("A change plan has been generated and is shown below. These changes have been saved to %s\n\n",
@@ -73,9 +81,15 @@ Generate a plan for updating git remotes:
 			for _, plan := range changeSet.Plans {
 				DisplayChangePlanForDirectory(plan)
 			}
+			DisplaySkippedRepositories(changeSet.Skipped)
+			DisplayUnpushedWork(changeSet)
+			DisplayBrokenRemotes(changeSet.Broken)
 			DisplayBundledErrorsPlan()
 			DisplayChangeCount(changeSet)
 		} else {
+			DisplaySkippedRepositories(changeSet.Skipped)
+			DisplayUnpushedWork(changeSet)
+			DisplayBrokenRemotes(changeSet.Broken)
 			DisplayBundledErrorsPlan()
 			fmt.Println("\nNo Changes found.")
 		}
@@ -85,13 +99,39 @@ Generate a plan for updating git remotes:
 
 func init() {
 	rootCmd.AddCommand(planCmd)
-	planCmd.Flags().StringVarP(&targetDir, "directory", "d", targetDir, "Set search directory")
+	planCmd.Flags().StringArrayVarP(&targetDirs, "directory", "d", nil, "Set search directory (may be repeated)")
+	planCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Skip the confirmation prompt")
+	planCmd.Flags().StringVar(&reposFrom, "repos-from", "", "Read repo paths from a file, one per line ('-' for stdin), instead of searching")
 	planCmd.Flags().StringVar(&targetRemoteURL, "find-url", defaultTargetHostname, "set remote url for remote update")
 	planCmd.Flags().StringVar(&newRemoteURL, "set-url", defaultNewHostname, "set target url for remote update")
 	planCmd.Flags().StringVar(&targetOrganization, "find-org", "", "set target org for remote update")
 	planCmd.Flags().StringVar(&newOrganization, "set-org", "", "set target org for remote update")
 	planCmd.Flags().StringVar(&remoteType, "remote-type", defaultRemoteType, "set target org for remote update")
 	planCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	planCmd.Flags().Bool(keyExcludeUnpushed, false, "Exclude repos with unpushed commits, branches or stashes from the plan")
+	planCmd.Flags().Bool(keyStripCreds, false, "Drop the user and password of rewritten http(s) urls instead of keeping them")
+	planCmd.Flags().String(keyGitSuffix, string(grout.GitSuffixPreserve), "The .git suffix of rewritten urls: always, never or preserve")
+	planCmd.Flags().Bool(keyNormalizeOnly, false, "Only apply --git-suffix to urls already on the new host, without moving any")
+	planCmd.Flags().Bool(keyUpgradeProtocol, false, "Only move http urls to https and git:// urls to --git-protocol-to, on any host")
+	planCmd.Flags().String(keyGitProtocolTo, grout.ProtocolHTTPS, "What git:// urls are upgraded or moved to: https or ssh")
+	planCmd.Flags().String(keySSHConfig, "", "ssh config used to resolve host aliases in ssh urls (default ~/.ssh/config, 'none' to not resolve them)")
+	planCmd.Flags().String(keySSHAliases, sshAliasesKeep, "What rewritten urls do with ssh host aliases: keep, or replace with the real host")
+
+	// flags take precedence over the environment, profile and defaults
+	bindFlag(keyFindURL, planCmd.Flags().Lookup(keyFindURL))
+	bindFlag(keySetURL, planCmd.Flags().Lookup(keySetURL))
+	bindFlag(keyFindOrg, planCmd.Flags().Lookup(keyFindOrg))
+	bindFlag(keySetOrg, planCmd.Flags().Lookup(keySetOrg))
+	bindFlag(keyRemoteType, planCmd.Flags().Lookup(keyRemoteType))
+	bindFlag(keyExcludeUnpushed, planCmd.Flags().Lookup(keyExcludeUnpushed))
+	bindFlag(keyStripCreds, planCmd.Flags().Lookup(keyStripCreds))
+	bindFlag(keyGitSuffix, planCmd.Flags().Lookup(keyGitSuffix))
+	bindFlag(keyNormalizeOnly, planCmd.Flags().Lookup(keyNormalizeOnly))
+	bindFlag(keyUpgradeProtocol, planCmd.Flags().Lookup(keyUpgradeProtocol))
+	bindFlag(keyGitProtocolTo, planCmd.Flags().Lookup(keyGitProtocolTo))
+	bindFlag(keySSHConfig, planCmd.Flags().Lookup(keySSHConfig))
+	bindFlag(keySSHAliases, planCmd.Flags().Lookup(keySSHAliases))
+	boundFlags[keyDirectories] = planCmd.Flags().Lookup("directory")
 
 	// clean and validate parameters
 	newRemoteURL = trimSlashSuffix(newRemoteURL)
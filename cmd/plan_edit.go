@@ -0,0 +1,131 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+)
+
+var editOutputFile string
+var editInteractive bool
+var excludeRepos []string
+var includeRepos []string
+var excludeRemotes []string
+var includeRemotes []string
+
+// A single toggleable entry in the interactive checklist. Change is -1
+// when the entry refers to the whole repo
+type checklistItem struct {
+	Plan   int
+	Change int
+}
+
+// planEditCmd represents the plan edit command
+var planEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Exclude or re-include repos and changes in an existing plan",
+	Long: `
+Exclude or re-include repos and changes in an existing plan:
+
+  Repo selectors are a repo name or a glob matched against the repo path.
+  Remote selectors are a remote name glob, optionally scoped to repos with
+  "remote@repo-selector". Exclusions are applied before inclusions. Excluded
+  entries stay in the plan file so they can be re-included later, but are
+  not counted or applied.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := ioutil.ReadFile(planFile)
+		if err != nil {
+			fmt.Printf("Unable to read plan file: %s\n", err)
+			os.Exit(1)
+		}
+		set, err := grout.DecodePlan(data)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		set.Edit(grout.EditOptions{
+			ExcludeRepos:   excludeRepos,
+			IncludeRepos:   includeRepos,
+			ExcludeRemotes: excludeRemotes,
+			IncludeRemotes: includeRemotes,
+		})
+		if editInteractive && !editChangeSetInteractively(&set) {
+			fmt.Println("Aborting changes")
+			os.Exit(0)
+		}
+
+		outFile := editOutputFile
+		if len(outFile) == 0 {
+			outFile = planFile
+		}
+		if err := writeChangeSetToFile(set, outFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		DisplayChangeCount(set)
+		fmt.Printf("Plan saved to %s\n", outFile)
+	},
+}
+
+// Show the plan as a checklist and toggle entries until the user writes
+// or quits. Returns false if the user quit without writing
+func editChangeSetInteractively(set *grout.ChangeSet) bool {
+	for {
+		fmt.Println()
+		items := DisplayChecklist(*set)
+		input := promptForInput("\nEnter numbers to toggle (e.g. '1 4'), 'w' to write the plan, 'q' to quit: ", "")
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "w":
+			return true
+		case "q":
+			return false
+		}
+		for _, field := range strings.FieldsFunc(input, func(r rune) bool { return r == ' ' || r == ',' }) {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 1 || n > len(items) {
+				fmt.Printf("Ignoring invalid selection: %s\n", field)
+				continue
+			}
+			item := items[n-1]
+			plan := &set.Plans[item.Plan]
+			if item.Change < 0 {
+				plan.Excluded = !plan.Excluded
+			} else {
+				plan.Changes[item.Change].Excluded = !plan.Changes[item.Change].Excluded
+			}
+		}
+		set.Recount()
+	}
+}
+
+func init() {
+	planCmd.AddCommand(planEditCmd)
+	planEditCmd.Flags().StringVarP(&planFile, "file", "f", defaultPlanFile, "Target a plan file")
+	planEditCmd.Flags().StringVarP(&editOutputFile, "out", "o", "", "Write the edited plan to a different file")
+	planEditCmd.Flags().BoolVarP(&editInteractive, "interactive", "i", false, "Choose repos and changes from a checklist")
+	planEditCmd.Flags().StringSliceVar(&excludeRepos, "exclude-repo", nil, "Exclude repos matching a name or path glob")
+	planEditCmd.Flags().StringSliceVar(&includeRepos, "include-repo", nil, "Re-include repos matching a name or path glob")
+	planEditCmd.Flags().StringSliceVar(&excludeRemotes, "exclude-remote", nil, "Exclude changes to a remote (remote[@repo])")
+	planEditCmd.Flags().StringSliceVar(&includeRemotes, "include-remote", nil, "Re-include changes to a remote (remote[@repo])")
+}
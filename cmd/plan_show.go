@@ -0,0 +1,79 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText     = "text"
+	outputJSON     = "json"
+	outputMarkdown = "markdown"
+)
+
+var outputFormat string
+
+// planShowCmd represents the plan show command
+var planShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Display an existing plan",
+	Long: `
+Display an existing plan:
+
+  Load a plan file and render it without making any changes. Output may be
+  text (the same format used by plan and update), json or markdown.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		set, err := grout.ReadPlanFile(planFile)
+		if err != nil {
+			fmt.Printf("Unable to read plan file: %s\n", err)
+			os.Exit(1)
+		}
+
+		switch outputFormat {
+		case outputText:
+			for _, plan := range set.Plans {
+				DisplayChangePlanForDirectory(plan)
+			}
+			DisplaySkippedRepositories(set.Skipped)
+			DisplayBrokenRemotes(set.Broken)
+			DisplayChangeCount(set)
+		case outputJSON:
+			jsonStr, err := json.MarshalIndent(set.Redacted(), "", twoSpaces)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonStr))
+		case outputMarkdown:
+			DisplayChangeSetMarkdown(set)
+		default:
+			fmt.Printf("Unknown output format: %s\n", outputFormat)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	planCmd.AddCommand(planShowCmd)
+	planShowCmd.Flags().StringVarP(&planFile, "file", "f", defaultPlanFile, "Target a plan file")
+	planShowCmd.Flags().StringVarP(&outputFormat, "output", "o", outputText, "Output format: text, json or markdown")
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+)
+
+// planValidateCmd represents the plan validate command
+var planValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check a plan against its schema and the filesystem",
+	Long: `
+Check a plan against its schema and the filesystem:
+
+  Load a plan file and confirm that it matches the plan schema, that every
+  repo and remote it references still exists, and that every url parses.
+  Nothing is modified. Exits non-zero if any problem is found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := ioutil.ReadFile(planFile)
+		if err != nil {
+			fmt.Printf("Unable to read plan file: %s\n", err)
+			os.Exit(1)
+		}
+		set, err := grout.DecodePlan(data)
+		if err != nil {
+			DisplayValidationErrors(planFile, []error{err})
+			os.Exit(1)
+		}
+
+		errs := grout.Validate(cmd.Context(), backend, set)
+		DisplayValidationErrors(planFile, errs)
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	planCmd.AddCommand(planValidateCmd)
+	planValidateCmd.Flags().StringVarP(&planFile, "file", "f", defaultPlanFile, "Target a plan file")
+}
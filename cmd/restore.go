@@ -0,0 +1,117 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+)
+
+var forceRestore bool
+var listRestore bool
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore [run] [repo...]",
+	Short: "Restore configs backed up before an update",
+	Long: `
+Restore configs backed up before an update:
+
+  Every update copies each repo's .git/config to a run directory under
+  ~/.local/state/grout/runs (or --backup-dir) before changing it. With no
+  arguments, restore lists the runs. Given a run ID, or "latest", it restores
+  every repo in that run, or only the repos matching the given name or path
+  globs. A repo whose config has changed since the run is left alone unless
+  --force is set.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := grout.BackupStore{Dir: backupDir}
+		if len(args) == 0 {
+			runs, err := store.Runs()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			DisplayBackupRuns(store, runs)
+			return
+		}
+
+		run, err := store.Run(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		entries := selectBackupEntries(run, args[1:])
+		if listRestore {
+			DisplayBackupEntries(run, entries)
+			return
+		}
+		if len(entries) == 0 {
+			fmt.Println("No backed up repos match")
+			os.Exit(1)
+		}
+
+		failed := 0
+		for _, entry := range entries {
+			err := run.Restore(entry, forceRestore)
+			DisplayRestoreResult(entry, err)
+			if err != nil {
+				failed++
+			}
+		}
+		fmt.Printf("\nRestored %d of %d repo(s) from run %s\n", len(entries)-failed, len(entries), run.ID)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// The entries of a run matching any of the repo selectors, or every entry
+// if there are none
+func selectBackupEntries(run *grout.BackupRun, selectors []string) []grout.BackupEntry {
+	if len(selectors) == 0 {
+		return run.Entries
+	}
+	var entries []grout.BackupEntry
+	for _, entry := range run.Entries {
+		repo := grout.LocalRepository{Name: entry.Name, Path: entry.Path}
+		for _, selector := range selectors {
+			if grout.RepoMatches(repo, selector) {
+				entries = append(entries, entry)
+				break
+			}
+		}
+	}
+	return entries
+}
+
+// Explain why a restore was refused
+func restoreErrorHint(err error) string {
+	if errors.Is(err, grout.ErrConfigChanged) {
+		return " (use --force to overwrite)"
+	}
+	return ""
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().BoolVar(&forceRestore, "force", false, "Restore configs even if they have changed since the run")
+	restoreCmd.Flags().BoolVarP(&listRestore, "list", "l", false, "List the repos in a run instead of restoring them")
+}
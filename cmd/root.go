@@ -27,6 +27,8 @@ import (
 	"github.com/spf13/viper"
 )
 
+var noTUI bool
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "grout",
@@ -38,25 +40,44 @@ This command by itself will run grout in interactive mode.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
+		if !noTUI && !reviewMode && isInteractiveTerminal() {
+			m, err := runTUI(cmd.Context())
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			DisplaySkippedRepositories(m.set.Skipped)
+			DisplayBrokenRemotes(m.set.Broken)
+			if m.applied {
+				DisplayBundledErrorsUpdate()
+				DisplayChangeResult(m.set)
+			} else {
+				DisplayBundledErrorsPlan()
+			}
+			return
+		}
+
 		fmt.Println("Running interactive mode.")
 		IAmGrout()
 		fmt.Println("---------------------")
 
 		targetRemoteURL = promptForInput(fmt.Sprintf("Target Remote Hostname (%s): ",
-			defaultTargetHostname), defaultTargetHostname)
+			targetRemoteURL), targetRemoteURL)
 		newRemoteURL = promptForInput(fmt.Sprintf("New Remote Hostname (%s): ",
-			defaultNewHostname), defaultNewHostname)
-		targetOrganization = promptForInput(fmt.Sprintf("Target Username/Org (%s): ", "Target all if not set"), "")
+			newRemoteURL), newRemoteURL)
+		targetOrganization = promptForInput(fmt.Sprintf("Target Username/Org (%s): ", "Target all if not set"), targetOrganization)
 		newOrganization = promptForInput(fmt.Sprintf("New Username/Org (%s): ", "Unchanged if not set"), newOrganization)
-		targetDir = promptForInput(fmt.Sprintf("Target local directory (%s): ",
-			"Defaults to './' if not set"), targetDir)
+		dirs := promptForInput(fmt.Sprintf("Target local directories, comma separated (%s): ",
+			strings.Join(targetDirs, ", ")), strings.Join(targetDirs, ","))
+		targetDirs = splitDirectoryList(dirs)
 
 		// clean validate parameters
 		newRemoteURL = trimSlashSuffix(newRemoteURL)
 		targetRemoteURL = trimSlashSuffix(targetRemoteURL)
 		newOrganization = strings.ReplaceAll(newOrganization, "/", "")
 		targetOrganization = strings.ReplaceAll(targetOrganization, "/", "")
-		if err := verifyTargetDirIsAbs(); err != nil {
+		if err := resolveTargetDirs(); err != nil {
+			fmt.Printf("\n%s - Aborting\n", err)
 			os.Exit(1)
 		}
 
@@ -72,35 +93,50 @@ This command by itself will run grout in interactive mode.`,
 		fmt.Println("Generating plan...")
 
 		// Search for git repos
-		err := filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
-			err = mapRepository(path, info, err, &repoMap)
-			if err != nil {
-				fmt.Println(err)
-				return err
-			}
-			return nil
-		})
+		repoMap, err := searchForRepositories(cmd.Context(), nil)
 		if err != nil {
 			log.Println(err)
 		}
 
 		// calculate changes for repos in repoMap
-		changeSet = createChangeSetFromMap(repoMap)
-		writeChangeSetToFile(changeSet, defaultPlanFile)
+		changeSet := createChangeSetFromMap(cmd.Context(), repoMap)
+		if err := writeChangeSetToFile(changeSet, defaultPlanFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		fmt.Printf("A change plan has been generated and is shown below. These changes have been saved to %s\n\n",
 			defaultPlanFile)
 		for _, plan := range changeSet.Plans {
 			DisplayChangePlanForDirectory(plan)
 		}
+		DisplaySkippedRepositories(changeSet.Skipped)
+		DisplayUnpushedWork(changeSet)
+		DisplayBrokenRemotes(changeSet.Broken)
 		DisplayBundledErrorsPlan()
 
+		// Review each repo if requested, saving the decisions back to the plan
+		if reviewMode && changeSet.Count > 0 {
+			if !reviewChangeSet(&changeSet) {
+				fmt.Println("Aborting changes")
+				os.Exit(0)
+			}
+			if err := writeChangeSetToFile(changeSet, defaultPlanFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("Review decisions have been saved to %s\n", defaultPlanFile)
+		}
+
 		// Display intent of plan and prompt for confirmation before proceeding
 		if changeSet.Count > 0 {
 			DisplayChangeIntention(changeSet)
-			fmt.Println("---------------------")
-			input := promptForInput("Enter '"+Yes+"' to accept and apply these changes: ", "")
+			input := Yes
+			if !reviewMode {
+				fmt.Println("---------------------")
+				input = promptForInput("Enter '"+Yes+"' to accept and apply these changes: ", "")
+			}
 			if strings.Compare(strings.ToLower(input), Yes) == 0 {
-				err = executeChanges(changeSet)
+				err = executeChanges(cmd.Context(), changeSet)
 				if err != nil {
 					fmt.Println("grout was unable to execute the changes")
 					os.Exit(1)
@@ -141,7 +177,7 @@ func init() {
 		os.Exit(1)
 	}
 
-	targetDir = currentDir
+	targetDirs = []string{currentDir}
 	remoteType = defaultRemoteType
 
 	// Here you will define your flags and configuration settings.
@@ -153,6 +189,8 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.Flags().BoolVar(&reviewMode, "review", false, "Review and accept, skip or edit each repo before applying")
+	rootCmd.Flags().BoolVar(&noTUI, "no-tui", false, "Use line-by-line prompts instead of the full-screen interface")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -173,10 +211,26 @@ func initConfig() {
 		viper.SetConfigName(".grout_bin")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, e.g. GROUT_FIND_URL
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+
+	if err := loadGitConfigDefaults(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := loadProfile(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := applyConfig(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,571 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+)
+
+// Screens of the full-screen terminal UI, in the order they are normally visited
+const (
+	screenForm = iota
+	screenScan
+	screenTree
+	screenApply
+)
+
+// Actions requested by the model that the runner has to carry out
+const (
+	actionNone = iota
+	actionScan
+	actionApply
+	actionQuit
+)
+
+// Keys that are not plain printable runes
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyEnter     = "enter"
+	keyTab       = "tab"
+	keyBackTab   = "backtab"
+	keyBackspace = "backspace"
+	keyEsc       = "esc"
+	keyCtrlC     = "ctrl+c"
+	keySpace     = " "
+)
+
+// Apply statuses shown per repo on the apply screen
+const (
+	statusPending  = "pending"
+	statusApplying = "applying"
+	statusDone     = "done"
+	statusFailed   = "failed"
+	statusSkipped  = "skipped"
+)
+
+// An editable parameter on the form screen
+type formField struct {
+	Label    string
+	Hint     string
+	Value    string
+	Set      func(string)
+	Validate func(string) error
+}
+
+// A visible line in the plan tree. Change is -1 for repo rows
+type treeRow struct {
+	Plan   int
+	Change int
+}
+
+type tuiModel struct {
+	screen int
+	height int
+
+	fields  []formField
+	focus   int
+	formErr string
+
+	scanDirs  int
+	scanRepos int
+	scanPath  string
+
+	set       grout.ChangeSet
+	rows      []treeRow
+	cursor    int
+	offset    int
+	collapsed map[int]bool
+	filter    string
+	filtering bool
+
+	statuses  []string
+	applying  bool
+	applied   bool
+	confirmed bool
+}
+
+func newTUIModel() *tuiModel {
+	m := &tuiModel{screen: screenForm, height: 24, collapsed: map[int]bool{}}
+	m.fields = []formField{
+		{Label: "Search directories", Hint: "comma separated, ~ and $VARS allowed", Value: strings.Join(targetDirs, ","),
+			Set: func(value string) { targetDirs = splitDirectoryList(value) }, Validate: validateSearchDirs},
+		{Label: "Target remote hostname", Hint: "e.g. github.com", Value: targetRemoteURL,
+			Set: setString(&targetRemoteURL), Validate: validateHostname},
+		{Label: "New remote hostname", Hint: "e.g. gitlab.com", Value: newRemoteURL,
+			Set: setString(&newRemoteURL), Validate: validateHostname},
+		{Label: "Target username/org", Hint: "target all if not set", Value: targetOrganization,
+			Set: setString(&targetOrganization), Validate: validateOrganization},
+		{Label: "New username/org", Hint: "unchanged if not set", Value: newOrganization,
+			Set: setString(&newOrganization), Validate: validateOrganization},
+		{Label: "Remote type", Hint: "https or http", Value: remoteType,
+			Set: setString(&remoteType), Validate: validateRemoteType},
+	}
+	return m
+}
+
+func setString(target *string) func(string) {
+	return func(value string) { *target = value }
+}
+
+func validateSearchDirs(value string) error {
+	dirs := splitDirectoryList(value)
+	if len(dirs) == 0 {
+		return errors.New("at least one directory is required")
+	}
+	for _, dir := range dirs {
+		resolved, err := grout.NormalizePath(dir)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s: not a directory", dir)
+		}
+	}
+	return nil
+}
+
+func validateHostname(value string) error {
+	value = trimSlashSuffix(value)
+	if len(value) == 0 {
+		return errors.New("a hostname is required")
+	}
+	if strings.ContainsAny(value, " /:@") {
+		return errors.New("enter a bare hostname without scheme, user or path")
+	}
+	return nil
+}
+
+func validateOrganization(value string) error {
+	if strings.ContainsAny(strings.Trim(value, "/"), " /") {
+		return errors.New("enter a single username or org")
+	}
+	return nil
+}
+
+func validateRemoteType(value string) error {
+	if value != https && value != http {
+		return fmt.Errorf("must be %s or %s", https, http)
+	}
+	return nil
+}
+
+// Handle a key press, returning the action the runner should take next
+func (m *tuiModel) handleKey(key string) int {
+	if key == keyCtrlC {
+		return actionQuit
+	}
+	switch m.screen {
+	case screenForm:
+		return m.handleFormKey(key)
+	case screenTree:
+		return m.handleTreeKey(key)
+	case screenApply:
+		return m.handleApplyKey(key)
+	}
+	return actionNone
+}
+
+func (m *tuiModel) handleFormKey(key string) int {
+	field := &m.fields[m.focus]
+	switch key {
+	case keyUp, keyBackTab:
+		m.focus = (m.focus + len(m.fields) - 1) % len(m.fields)
+	case keyDown, keyTab:
+		m.focus = (m.focus + 1) % len(m.fields)
+	case keyBackspace:
+		if len(field.Value) > 0 {
+			runes := []rune(field.Value)
+			field.Value = string(runes[:len(runes)-1])
+		}
+	case keyEsc:
+		return actionQuit
+	case keyEnter:
+		if m.focus < len(m.fields)-1 {
+			m.focus++
+			return actionNone
+		}
+		if m.submitForm() {
+			return actionScan
+		}
+	default:
+		if len([]rune(key)) == 1 {
+			field.Value += key
+		}
+	}
+	return actionNone
+}
+
+// Validate every field and, if they all pass, copy the cleaned values
+// into the parameters used for planning
+func (m *tuiModel) submitForm() bool {
+	for i, field := range m.fields {
+		if err := field.Validate(field.Value); err != nil {
+			m.focus = i
+			m.formErr = fmt.Sprintf("%s: %s", field.Label, err)
+			return false
+		}
+	}
+	m.formErr = ""
+	for _, field := range m.fields {
+		field.Set(field.Value)
+	}
+	if err := resolveTargetDirs(); err != nil {
+		m.focus = 0
+		m.formErr = err.Error()
+		return false
+	}
+	newRemoteURL = trimSlashSuffix(newRemoteURL)
+	targetRemoteURL = trimSlashSuffix(targetRemoteURL)
+	newOrganization = strings.ReplaceAll(newOrganization, "/", "")
+	targetOrganization = strings.ReplaceAll(targetOrganization, "/", "")
+	return true
+}
+
+func (m *tuiModel) handleTreeKey(key string) int {
+	if m.filtering {
+		switch key {
+		case keyEnter, keyEsc:
+			m.filtering = false
+		case keyBackspace:
+			if len(m.filter) > 0 {
+				runes := []rune(m.filter)
+				m.filter = string(runes[:len(runes)-1])
+			}
+		default:
+			if len([]rune(key)) == 1 {
+				m.filter += key
+			}
+		}
+		m.buildRows()
+		return actionNone
+	}
+
+	switch key {
+	case keyUp, "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case keyDown, "j":
+		if m.cursor < len(m.rows)-1 {
+			m.cursor++
+		}
+	case keyLeft, "h":
+		if len(m.rows) > 0 {
+			row := m.rows[m.cursor]
+			m.collapsed[row.Plan] = true
+			m.buildRows()
+			m.moveCursorTo(treeRow{Plan: row.Plan, Change: -1})
+		}
+	case keyRight, "l":
+		if len(m.rows) > 0 {
+			m.collapsed[m.rows[m.cursor].Plan] = false
+			m.buildRows()
+		}
+	case keySpace:
+		if len(m.rows) > 0 {
+			row := m.rows[m.cursor]
+			plan := &m.set.Plans[row.Plan]
+			if row.Change < 0 {
+				plan.Excluded = !plan.Excluded
+			} else {
+				plan.Changes[row.Change].Excluded = !plan.Changes[row.Change].Excluded
+			}
+			m.set.Recount()
+		}
+	case "/":
+		m.filtering = true
+	case keyEsc:
+		if len(m.filter) > 0 {
+			m.filter = ""
+			m.buildRows()
+		} else {
+			m.screen = screenForm
+		}
+	case "b":
+		m.screen = screenForm
+	case "a":
+		if m.set.Count > 0 {
+			m.screen = screenApply
+			m.confirmed = false
+		}
+	case "q":
+		return actionQuit
+	}
+	m.scroll()
+	return actionNone
+}
+
+func (m *tuiModel) handleApplyKey(key string) int {
+	if m.applying {
+		return actionNone
+	}
+	if m.applied {
+		if key == "q" || key == keyEnter || key == keyEsc {
+			return actionQuit
+		}
+		return actionNone
+	}
+	switch key {
+	case "y", keyEnter:
+		// changing repos with unpushed work takes an explicit y
+		if key == keyEnter && len(m.selectedUnpushed()) > 0 {
+			return actionNone
+		}
+		m.confirmed = true
+		m.statuses = make([]string, len(m.set.Plans))
+		for i, plan := range m.set.Plans {
+			m.statuses[i] = statusPending
+			if plan.Excluded {
+				m.statuses[i] = statusSkipped
+			}
+		}
+		return actionApply
+	case "n", keyEsc, "b":
+		m.screen = screenTree
+	case "q":
+		return actionQuit
+	}
+	return actionNone
+}
+
+// Load a freshly generated grout.ChangeSet into the tree view. Repos with
+// unpushed work start unselected, so they are only changed if picked
+func (m *tuiModel) setChangeSet(set grout.ChangeSet) {
+	for i := range set.Plans {
+		if set.Plans[i].UnpushedWork != nil {
+			set.Plans[i].Excluded = true
+		}
+	}
+	set.Recount()
+	m.set = set
+	m.cursor = 0
+	m.offset = 0
+	m.filter = ""
+	m.collapsed = map[int]bool{}
+	m.applied = false
+	m.statuses = nil
+	m.buildRows()
+	m.screen = screenTree
+}
+
+// Rebuild the visible rows from the plan, honouring collapsed repos and the filter
+func (m *tuiModel) buildRows() {
+	m.rows = nil
+	filter := strings.ToLower(m.filter)
+	for i, plan := range m.set.Plans {
+		repoMatchesFilter := strings.Contains(strings.ToLower(plan.Repo.Name), filter) ||
+			strings.Contains(strings.ToLower(plan.Repo.Path), filter)
+
+		var changeRows []treeRow
+		for j, change := range plan.Changes {
+			text := strings.ToLower(change.Name + " " + grout.RedactURL(strings.Join(change.CurrentURLs, " ")) + " " +
+				grout.RedactURL(strings.Join(change.NewURLs, " ")))
+			if repoMatchesFilter || strings.Contains(text, filter) {
+				changeRows = append(changeRows, treeRow{Plan: i, Change: j})
+			}
+		}
+		if !repoMatchesFilter && len(changeRows) == 0 {
+			continue
+		}
+		m.rows = append(m.rows, treeRow{Plan: i, Change: -1})
+		if !m.collapsed[i] {
+			m.rows = append(m.rows, changeRows...)
+		}
+	}
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	m.scroll()
+}
+
+// The selected repos that have unpushed work
+func (m *tuiModel) selectedUnpushed() []grout.RepoPlan {
+	var plans []grout.RepoPlan
+	for _, plan := range m.set.Plans {
+		if !plan.Excluded && plan.UnpushedWork != nil {
+			plans = append(plans, plan)
+		}
+	}
+	return plans
+}
+
+func (m *tuiModel) moveCursorTo(target treeRow) {
+	for i, row := range m.rows {
+		if row == target {
+			m.cursor = i
+			return
+		}
+	}
+}
+
+// Keep the cursor inside the visible window of the tree
+func (m *tuiModel) scroll() {
+	visible := m.treeHeight()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+visible {
+		m.offset = m.cursor - visible + 1
+	}
+}
+
+func (m *tuiModel) treeHeight() int {
+	// leave room for the header and footer lines
+	if m.height-6 < 1 {
+		return 1
+	}
+	return m.height - 6
+}
+
+// Render the current screen as a list of lines
+func (m *tuiModel) view() []string {
+	lines := []string{"grout - " + SpellItOUt, ""}
+	switch m.screen {
+	case screenForm:
+		lines = append(lines, m.viewForm()...)
+	case screenScan:
+		lines = append(lines,
+			"Scanning for repositories...",
+			"",
+			fmt.Sprintf("%sDirectories searched: %d", twoSpaces, m.scanDirs),
+			fmt.Sprintf("%sRepositories found:   %d", twoSpaces, m.scanRepos),
+			fmt.Sprintf("%s%s", twoSpaces, m.scanPath))
+	case screenTree:
+		lines = append(lines, m.viewTree()...)
+	case screenApply:
+		lines = append(lines, m.viewApply()...)
+	}
+	return lines
+}
+
+func (m *tuiModel) viewForm() []string {
+	lines := []string{"Migration parameters", ""}
+	for i, field := range m.fields {
+		cursor := twoSpaces
+		if i == m.focus {
+			cursor = "> "
+		}
+		line := fmt.Sprintf("%s%-24s %s", cursor, field.Label+":", field.Value)
+		if i == m.focus {
+			line += "_"
+		}
+		if len(field.Value) == 0 {
+			line += "  (" + field.Hint + ")"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "")
+	if len(m.formErr) > 0 {
+		lines = append(lines, "! "+m.formErr, "")
+	}
+	return append(lines, "up/down: move  enter: next / scan  esc: quit")
+}
+
+func (m *tuiModel) viewTree() []string {
+	header := fmt.Sprintf("%d change(s) selected across %d repo(s)", m.set.Count, len(m.set.Plans))
+	if errorBundle.Count > 0 {
+		header += fmt.Sprintf("  [%d scan error(s)]", errorBundle.Count)
+	}
+	if len(m.set.Skipped) > 0 {
+		header += fmt.Sprintf("  [%d skipped repo(s)]", len(m.set.Skipped))
+	}
+	if len(m.set.Broken) > 0 {
+		header += fmt.Sprintf("  [%d broken remote(s)]", len(m.set.Broken))
+	}
+	lines := []string{header}
+
+	if len(m.rows) == 0 {
+		lines = append(lines, "", twoSpaces+"No changes found.")
+	}
+	end := m.offset + m.treeHeight()
+	if end > len(m.rows) {
+		end = len(m.rows)
+	}
+	for i := m.offset; i < end; i++ {
+		row := m.rows[i]
+		plan := m.set.Plans[row.Plan]
+		cursor := twoSpaces
+		if i == m.cursor {
+			cursor = "> "
+		}
+		if row.Change < 0 {
+			fold := "v"
+			if m.collapsed[row.Plan] {
+				fold = ">"
+			}
+			line := fmt.Sprintf("%s%s %s %s  %s", cursor, fold, checkbox(!plan.Excluded), plan.Repo.Name, plan.Repo.Path)
+			if plan.UnpushedWork != nil {
+				line += "  ! unpushed: " + plan.UnpushedWork.String()
+			}
+			lines = append(lines, line)
+			continue
+		}
+		change := plan.Changes[row.Change]
+		for k := 0; k < len(change.NewURLs) && k < len(change.CurrentURLs); k++ {
+			lines = append(lines, fmt.Sprintf("%s    %s %s: %s -> %s%s", cursor, checkbox(!change.Excluded),
+				change.Name, grout.RedactURL(change.CurrentURLs[k]), grout.RedactURL(change.NewURLs[k]), sshAliasLabel(change)))
+		}
+	}
+
+	lines = append(lines, "")
+	if m.filtering || len(m.filter) > 0 {
+		lines = append(lines, "filter: "+m.filter)
+	}
+	return append(lines, "up/down: move  left/right: fold  space: toggle  /: filter  a: apply  b: back  q: quit")
+}
+
+func (m *tuiModel) viewApply() []string {
+	var lines []string
+	if !m.confirmed {
+		lines = append(lines,
+			fmt.Sprintf("GRUT will perform %d change(s) across %d repo(s)", m.set.Count, len(m.set.Plans)),
+			"")
+		unpushed := m.selectedUnpushed()
+		if len(unpushed) == 0 {
+			return append(lines, "y/enter: apply  n/esc: back to plan  q: quit")
+		}
+		lines = append(lines, fmt.Sprintf("WARNING: %d selected repo(s) have work that hasn't been pushed:", len(unpushed)))
+		for _, plan := range unpushed {
+			lines = append(lines, fmt.Sprintf("%s%s  %s", twoSpaces, plan.Repo.Name, plan.UnpushedWork))
+		}
+		return append(lines, "", "y: apply anyway  n/esc: back to plan  q: quit")
+	}
+	for i, plan := range m.set.Plans {
+		lines = append(lines, fmt.Sprintf("%s%-9s %s  %s", twoSpaces, m.statuses[i], plan.Repo.Name, plan.Repo.Path))
+	}
+	lines = append(lines, "")
+	if m.applied {
+		lines = append(lines, fmt.Sprintf("Completed %d change(s). Press q to quit.", m.set.Count))
+	} else {
+		lines = append(lines, "Applying changes...")
+	}
+	return lines
+}
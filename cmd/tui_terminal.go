@@ -0,0 +1,187 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"golang.org/x/term"
+)
+
+// ANSI escape sequences used to drive the terminal
+const (
+	altScreenOn  = "\x1b[?1049h"
+	altScreenOff = "\x1b[?1049l"
+	cursorHide   = "\x1b[?25l"
+	cursorShow   = "\x1b[?25h"
+	clearScreen  = "\x1b[H\x1b[2J"
+)
+
+// Reports whether stdin and stdout are both attached to a terminal
+func isInteractiveTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Run the full-screen terminal UI until the user quits. The terminal is
+// always restored before returning, along with the final model state
+func runTUI(ctx context.Context) (*tuiModel, error) {
+	m := newTUIModel()
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return m, err
+	}
+	fmt.Print(altScreenOn + cursorHide)
+	defer func() {
+		fmt.Print(cursorShow + altScreenOff)
+		_ = term.Restore(fd, state)
+	}()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		if _, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil && height > 0 {
+			m.height = height
+		}
+		render(os.Stdout, m)
+
+		key, err := readKey(in)
+		if err != nil {
+			return m, err
+		}
+		switch m.handleKey(key) {
+		case actionQuit:
+			return m, nil
+		case actionScan:
+			scanForTUI(ctx, m)
+		case actionApply:
+			applyForTUI(ctx, m)
+		}
+	}
+}
+
+func render(out io.Writer, m *tuiModel) {
+	// raw mode doesn't translate newlines, so return the carriage explicitly
+	fmt.Fprint(out, clearScreen+strings.Join(m.view(), "\r\n"))
+}
+
+// Walk the search directory, redrawing progress as repos are found, and
+// load the resulting plan into the tree view
+func scanForTUI(ctx context.Context, m *tuiModel) {
+	m.screen = screenScan
+	m.scanDirs, m.scanRepos = 0, 0
+	errorBundle = ErrorBundle{}
+
+	repoMap, err := searchForRepositories(ctx, func(path string, info os.FileInfo, found int) {
+		if info.IsDir() {
+			m.scanDirs++
+			m.scanPath = path
+		}
+		if found != m.scanRepos || m.scanDirs%50 == 0 {
+			m.scanRepos = found
+			render(os.Stdout, m)
+		}
+	})
+	if err != nil {
+		errorBundle.add(err)
+	}
+
+	m.setChangeSet(createChangeSetFromMap(ctx, repoMap))
+}
+
+// Save the plan and apply it one repo at a time, redrawing the status of
+// each repo as it completes
+func applyForTUI(ctx context.Context, m *tuiModel) {
+	m.applying = true
+	// nothing is applied unless the plan it came from is on disk
+	if err := writeChangeSetToFile(m.set, defaultPlanFile); err != nil {
+		errorBundle.add(err)
+		m.applying = false
+		return
+	}
+	run, err := startBackupRun()
+	if err != nil {
+		errorBundle.add(err)
+		m.applying = false
+		return
+	}
+	applier := grout.NewApplier(grout.ApplyOptions{Backend: backend, Backups: run})
+	for i, plan := range m.set.Plans {
+		if m.statuses[i] == statusSkipped {
+			continue
+		}
+		m.statuses[i] = statusApplying
+		render(os.Stdout, m)
+		if err := applier.ApplyPlan(ctx, plan); err != nil {
+			m.statuses[i] = statusFailed
+			errorBundle.add(err)
+		} else {
+			m.statuses[i] = statusDone
+		}
+	}
+	m.applying = false
+	m.applied = true
+}
+
+// Read a single key press, translating escape sequences for the arrow
+// keys and shift+tab
+func readKey(in *bufio.Reader) (string, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch r {
+	case 3:
+		return keyCtrlC, nil
+	case '\r', '\n':
+		return keyEnter, nil
+	case '\t':
+		return keyTab, nil
+	case 127, 8:
+		return keyBackspace, nil
+	case 27:
+		if in.Buffered() == 0 {
+			return keyEsc, nil
+		}
+		next, _, err := in.ReadRune()
+		if err != nil || (next != '[' && next != 'O') {
+			return keyEsc, nil
+		}
+		code, _, err := in.ReadRune()
+		if err != nil {
+			return keyEsc, nil
+		}
+		switch code {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		case 'C':
+			return keyRight, nil
+		case 'D':
+			return keyLeft, nil
+		case 'Z':
+			return keyBackTab, nil
+		}
+		return keyEsc, nil
+	}
+	return string(r), nil
+}
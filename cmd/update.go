@@ -16,16 +16,20 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	//"github.com/go-git/go-git/v5"
 	"os"
 
+	"github.com/JoshRodstein/grout/pkg/grout"
 	"github.com/spf13/cobra"
 )
 
 var planFile string
+var reviewMode bool
+var fetchAfterUpdate bool
 
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
@@ -44,24 +48,46 @@ Execute changes in a plan:
 			fmt.Println("error initializing plan from file")
 			os.Exit(1)
 		}
-		fmt.Printf("A change plan has been loaded and is shown below. These changes have been saved to %s\n\n",
-			defaultPlanFile)
-		for _, plan := range changeSet.Plans {
-			DisplayChangePlanForDirectory(plan)
+		if reviewMode {
+			if !reviewChangeSet(&changeSet) {
+				fmt.Println("Aborting changes")
+				os.Exit(0)
+			}
+			if err := writeChangeSetToFile(changeSet, planFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("Review decisions have been saved to %s\n", planFile)
+		} else {
+			fmt.Printf("A change plan has been loaded and is shown below. These changes have been saved to %s\n\n",
+				defaultPlanFile)
+			for _, plan := range changeSet.Plans {
+				DisplayChangePlanForDirectory(plan)
+			}
 		}
 
 		if changeSet.Count > 0 {
 			DisplayChangeIntention(changeSet)
-			fmt.Println("---------------------")
-			input := promptForInput("Enter '"+Yes+"' to accept and apply these changes: ", "")
+			input := Yes
+			if !reviewMode {
+				fmt.Println("---------------------")
+				input = promptForInput("Enter '"+Yes+"' to accept and apply these changes: ", "")
+			}
 			if strings.Compare(strings.ToLower(input), Yes) == 0 {
-				err = executeChanges(changeSet)
+				err = executeChanges(cmd.Context(), changeSet)
 				if err != nil {
 					fmt.Println("grout was unable to execute the changes")
 					os.Exit(1)
 				}
 				DisplayBundledErrorsUpdate()
 				DisplayChangeResult(changeSet)
+				// verify compares against the old tracking branches, so it
+				// has to run before they are fetched over
+				verified := !verifyAfterUpdate || verifyChanges(cmd.Context(), changeSet)
+				fetched := !fetchAfterUpdate || fetchChanges(cmd.Context(), changeSet)
+				if !verified || !fetched {
+					os.Exit(1)
+				}
 			}
 		} else {
 			fmt.Println("No Changes found.")
@@ -70,9 +96,94 @@ Execute changes in a plan:
 	},
 }
 
+// Fetch each changed remote from its new url, printing each result as it
+// completes. Returns false if any fetch failed
+func fetchChanges(ctx context.Context, set grout.ChangeSet) bool {
+	fmt.Println("Fetching from the new remotes...")
+	results, err := grout.NewFetcher(grout.FetchOptions{Backend: backend, Progress: DisplayFetchResult}).Fetch(ctx, set)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	DisplayFetchSummary(results)
+	for _, result := range results {
+		if len(result.Error) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Walk through each repo in a plan and ask whether to accept, skip or edit
+// its changes. Decisions are recorded on the plan so they can be written
+// back to the plan file. Returns false if the user quit the review
+func reviewChangeSet(set *grout.ChangeSet) bool {
+	acceptAll := false
+	for i := range set.Plans {
+		plan := &set.Plans[i]
+		if plan.Excluded {
+			continue
+		}
+		if acceptAll {
+			plan.Decision = grout.DecisionAccepted
+			continue
+		}
+
+		fmt.Printf("---------------------\nRepo %d of %d\n", i+1, len(set.Plans))
+		DisplayChangePlanForDirectory(*plan)
+		for answered := false; !answered; {
+			input := promptForInput("[a]ccept, [s]kip, [e]dit new url(s), accept [A]ll remaining, [q]uit (a): ", "a")
+			answered = true
+			switch input {
+			case "a":
+				plan.Decision = grout.DecisionAccepted
+			case "s":
+				plan.Decision = grout.DecisionSkipped
+				plan.Excluded = true
+			case "e":
+				editPlanURLs(plan)
+				plan.Decision = grout.DecisionEdited
+			case "A":
+				plan.Decision = grout.DecisionAccepted
+				acceptAll = true
+			case "q":
+				return false
+			default:
+				fmt.Printf("Unknown choice: %s\n", input)
+				answered = false
+			}
+		}
+	}
+	set.Recount()
+	return true
+}
+
+// Prompt for a replacement for each new url in a plan, keeping the planned
+// url if nothing (or something unparseable) is entered
+func editPlanURLs(plan *grout.RepoPlan) {
+	for j := range plan.Changes {
+		change := &plan.Changes[j]
+		if change.Excluded {
+			continue
+		}
+		for k := range change.NewURLs {
+			newURL := promptForInput(fmt.Sprintf("%sNew url for %s (%s): ", twoSpaces, change.Name, grout.RedactURL(change.NewURLs[k])),
+				change.NewURLs[k])
+			if !grout.ValidRemoteURL(newURL) {
+				fmt.Printf("%sUnable to parse url %q, keeping %s\n", twoSpaces, grout.RedactURL(newURL), grout.RedactURL(change.NewURLs[k]))
+				continue
+			}
+			change.NewURLs[k] = newURL
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(updateCmd)
 	updateCmd.Flags().StringVarP(&planFile, "file", "f", defaultPlanFile, "Target a plan file")
+	updateCmd.Flags().BoolVar(&reviewMode, "review", false, "Review and accept, skip or edit each repo before applying")
+	updateCmd.Flags().BoolVar(&verifyAfterUpdate, "verify", false, "Verify the new urls with ls-remote after applying")
+	updateCmd.Flags().BoolVar(&fetchAfterUpdate, "fetch", false, "Fetch each changed remote from its new url after applying")
 
 	remoteType = defaultRemoteType
 }
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,12 +13,13 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/JoshRodstein/grout/pkg/grout"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/spf13/viper"
 )
 
-var mockRemoteURLs []string
-var mockRepo LocalRepository
-var mockChangeSet ChangeSet
+var mockRepo grout.LocalRepository
 var remoteURL1 string
 var remoteURL2 string
 var remoteURL3 string
@@ -26,337 +29,424 @@ func TestMain(m *testing.M) {
 	targetRemoteURL = "github.com"
 	newRemoteURL = "gitlab.com"
 	mockRepo.Name = "mockRepo"
-	remoteURL1 = "https://" + targetRemoteURL + "/OldUsername/" + mockRepo.Name + dotGit
-	remoteURL2 = "https://" + targetRemoteURL + "/JoshRodstein/" + mockRepo.Name + dotGit
-	remoteURL3 = "https://github.com" + "/SomeUsername/" + mockRepo.Name + dotGit
+	remoteURL1 = "https://" + targetRemoteURL + "/OldUsername/" + mockRepo.Name + grout.DotGit
+	remoteURL2 = "https://" + targetRemoteURL + "/JoshRodstein/" + mockRepo.Name + grout.DotGit
+	remoteURL3 = "https://bitbucket.org" + "/SomeUsername/" + mockRepo.Name + grout.DotGit
 	mockRepo.Path = fmt.Sprintf("/Users/mockUser/%s", mockRepo.Name)
 
 	os.Exit(m.Run())
 }
 
-func TestCreateNewRemoteURLsNotChange(t *testing.T) {
-	// reset test variables
-	var noMatchRemoteURLS []string
-	mockRepo.Remotes = nil
-	mockChangeSet.Count = 0
-
-	// Set remoteURL to NON matching URL (url != targetRemoteURL)
-	noMatchRemoteURLS = append(noMatchRemoteURLS, remoteURL3)
-	mockRepo.Remotes = append(mockRepo.Remotes, Remote{URLs: noMatchRemoteURLS})
-	newURLs := createNewRemoteURLs(mockRepo.Remotes[0].URLs, &mockChangeSet)
-
-	if len(newURLs) != 1 {
-		fmt.Println(newURLs)
+func TestTimeSlashURL(t *testing.T) {
+	url := "/this/is/a/path/"
+	url = trimSlashSuffix(url)
+	if strings.HasSuffix(url, "/") {
 		t.Error()
 	}
-	if mockChangeSet.Count != 0 {
+	url = trimSlashSuffix(url)
+	if !strings.HasSuffix(url, "h") {
 		t.Error()
 	}
-	unchangedURL := newURLs[0] != newRemoteURL+"/"+newOrganization+"/"+mockRepo.Name+dotGit
-	if unchangedURL != true {
-		t.Error()
+}
+
+func TestCleanPathElement(t *testing.T) {
+	if runtime.GOOS == Darwin || runtime.GOOS == Linux {
+		element := "/OldOrganization/"
+		element = removePathSeperators(element)
+		if strings.Contains(element, DarwinLinuxSep) {
+			t.Error()
+		}
+	} else if runtime.GOOS == Windows {
+		element := "\\OldOrganization\\"
+		element = removePathSeperators(element)
+		if strings.Contains(element, WindowsSep) {
+			t.Error()
+		}
 	}
+
 }
 
-func TestCreateNewRemoteURLsNoORG(t *testing.T) {
-	mockChangeSet.Count = 0
-	mockRepo.Remotes = nil
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL1)
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL2)
-	mockRepo.Remotes = append(mockRepo.Remotes, Remote{Name: "origin", URLs: mockRemoteURLs})
+func TestResolveTargetDirs(t *testing.T) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { targetDirs = nil }()
+	targetDirs = []string{currentDir, ".", "../cmd"}
+	err = resolveTargetDirs()
+	if err != nil {
+		t.Error(err)
+	}
+	for _, dir := range targetDirs {
+		if dir != currentDir {
+			t.Errorf("Expected %s to resolve to %s", dir, currentDir)
+		}
+	}
+}
 
-	newURLs := createNewRemoteURLs(mockRepo.Remotes[0].URLs, &mockChangeSet)
-	if len(newURLs) != 2 {
+func TestResolveTargetDirsMissing(t *testing.T) {
+	defer func() { targetDirs = nil }()
+	targetDirs = []string{"/this/is/an/abs/path", "this/is/NOT/an/abs/path"}
+	err := resolveTargetDirs()
+	if err == nil {
 		t.Error()
 	}
-	if mockChangeSet.Count != 2 {
-		t.Error()
+}
+
+func TestReviewChangeSet(t *testing.T) {
+	change := func() grout.RemoteChange {
+		return grout.RemoteChange{Name: "origin", CurrentURLs: []string{remoteURL1}, NewURLs: []string{remoteURL2}}
+	}
+	set := grout.ChangeSet{
+		Count: 4,
+		Plans: []grout.RepoPlan{
+			{Repo: grout.LocalRepository{Name: "one"}, Changes: []grout.RemoteChange{change()}},
+			{Repo: grout.LocalRepository{Name: "two"}, Changes: []grout.RemoteChange{change()}},
+			{Repo: grout.LocalRepository{Name: "three"}, Changes: []grout.RemoteChange{change()}},
+			{Repo: grout.LocalRepository{Name: "four"}, Changes: []grout.RemoteChange{change()}},
+		},
+	}
+	editedURL := "git@gitlab.com:NewOrg/mockRepo.git"
+	reader = bufio.NewReader(strings.NewReader("x\n\ns\ne\n" + editedURL + "\nA\n"))
+	defer func() { reader = bufio.NewReader(os.Stdin) }()
+
+	if !reviewChangeSet(&set) {
+		t.Fatal("Expected review to complete")
+	}
+	expected := []string{grout.DecisionAccepted, grout.DecisionSkipped, grout.DecisionEdited, grout.DecisionAccepted}
+	for i, plan := range set.Plans {
+		if plan.Decision != expected[i] {
+			t.Errorf("plans[%d].Decision: Expected %s, Got %s", i, expected[i], plan.Decision)
+		}
 	}
-	validNewURL1 := newURLs[0] == "https://"+newRemoteURL+"/OldOrganization/"+mockRepo.Name+dotGit
-	validNewURL2 := newURLs[1] == "https://"+newRemoteURL+"/jrodstein2/"+mockRepo.Name+dotGit
-	if validNewURL1 != true {
-		t.Error()
+	if !set.Plans[1].Excluded {
+		t.Error("Expected skipped repo to be excluded")
 	}
-	if validNewURL2 != true {
-		t.Error()
+	if set.Plans[2].Changes[0].NewURLs[0] != editedURL {
+		t.Errorf("Expected edited url %s, Got %s", editedURL, set.Plans[2].Changes[0].NewURLs[0])
+	}
+	if set.Count != 3 {
+		t.Errorf("set.Count: Expected 3, Got %d", set.Count)
+	}
+
+	reader = bufio.NewReader(strings.NewReader("q\n"))
+	set.Plans[0].Decision = ""
+	if reviewChangeSet(&set) {
+		t.Error("Expected review to be aborted")
 	}
 }
 
-func TestCreateNewRemoteURLsNewORG(t *testing.T) {
-	mockChangeSet.Count = 0
-	newOrganization = "NewOrg"
-	mockRepo.Remotes = nil
-	mockRemoteURLs = nil
+func TestTUIFormSubmit(t *testing.T) {
+	targetDirs = []string{t.TempDir()}
+	m := newTUIModel()
+	m.fields[1].Value = "github.com/"
+	m.fields[2].Value = "https://gitlab.com"
 
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL1)
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL2)
-	mockRepo.Remotes = append(mockRepo.Remotes, Remote{Name: "origin", URLs: mockRemoteURLs})
-	newURLs := createNewRemoteURLs(mockRepo.Remotes[0].URLs, &mockChangeSet)
+	m.focus = len(m.fields) - 1
+	if action := m.handleKey(keyEnter); action != actionNone {
+		t.Errorf("Expected invalid form to stay on screen, Got action %d", action)
+	}
+	if m.focus != 2 || len(m.formErr) == 0 {
+		t.Errorf("Expected focus on invalid field 2 with an error, Got %d %q", m.focus, m.formErr)
+	}
 
-	if len(newURLs) != 2 {
-		t.Error()
+	for range m.fields[2].Value {
+		m.handleKey(keyBackspace)
 	}
-	if mockChangeSet.Count != 2 {
-		t.Error()
+	for _, key := range "gitlab.com" {
+		m.handleKey(string(key))
 	}
-	validNewURL1 := newURLs[0] == "https://"+newRemoteURL+"/"+newOrganization+"/"+mockRepo.Name+dotGit
-	validNewURL2 := newURLs[1] == "https://"+newRemoteURL+"/"+newOrganization+"/"+mockRepo.Name+dotGit
-	if validNewURL1 != true {
-		t.Error()
+	m.focus = len(m.fields) - 1
+	if action := m.handleKey(keyEnter); action != actionScan {
+		t.Errorf("Expected valid form to start a scan, Got action %d: %s", action, m.formErr)
 	}
-	if validNewURL2 != true {
-		t.Error()
+	if targetRemoteURL != "github.com" || newRemoteURL != "gitlab.com" {
+		t.Errorf("Expected cleaned parameters, Got %s and %s", targetRemoteURL, newRemoteURL)
 	}
+	targetRemoteURL = "github.com"
 }
 
-func TestCreateChangeSetFromMap(t *testing.T) {
-	var testMap RepoMap
-
-	// re init globals
-	changeSet = ChangeSet{}
-	mockRepo.Remotes = nil
-	testMap.Repos = nil
-	mockRemoteURLs = nil
+func TestTUITree(t *testing.T) {
+	change := func(name string) grout.RemoteChange {
+		return grout.RemoteChange{Name: name, CurrentURLs: []string{remoteURL1}, NewURLs: []string{remoteURL2}}
+	}
+	m := newTUIModel()
+	m.setChangeSet(grout.ChangeSet{
+		Count: 3,
+		Plans: []grout.RepoPlan{
+			{Repo: grout.LocalRepository{Name: "api", Path: "/src/api/.git"}, Changes: []grout.RemoteChange{change("origin"), change("upstream")}},
+			{Repo: grout.LocalRepository{Name: "web", Path: "/src/web/.git"}, Changes: []grout.RemoteChange{change("origin")}},
+		},
+	})
+	if len(m.rows) != 5 {
+		t.Fatalf("Expected 5 rows, Got %d", len(m.rows))
+	}
 
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL1)
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL2)
-	mockRepo.Remotes = append(mockRepo.Remotes, Remote{Name: "origin", URLs: mockRemoteURLs})
-	testMap.Repos = append(testMap.Repos, mockRepo)
+	m.handleKey(keyDown)
+	m.handleKey(keySpace)
+	if !m.set.Plans[0].Changes[0].Excluded || m.set.Count != 2 {
+		t.Errorf("Expected api origin to be excluded, Got count %d", m.set.Count)
+	}
 
-	newTestChangeSet := createChangeSetFromMap(testMap)
+	m.handleKey(keyLeft)
+	if len(m.rows) != 3 || m.cursor != 0 {
+		t.Errorf("Expected api to collapse onto its repo row, Got %d rows, cursor %d", len(m.rows), m.cursor)
+	}
 
-	if newTestChangeSet.Count != 2 {
-		t.Errorf("newTestChangeSet.Count: Expected 2, Got %d", newTestChangeSet.Count)
+	for _, key := range []string{"/", "w", "e", "b", keyEnter} {
+		m.handleKey(key)
+	}
+	if len(m.rows) != 2 || m.rows[0].Plan != 1 {
+		t.Errorf("Expected filter to leave only web, Got %v", m.rows)
 	}
 
+	if m.handleKey("a"); m.screen != screenApply {
+		t.Error("Expected apply screen")
+	}
+	if action := m.handleKey("y"); action != actionApply {
+		t.Errorf("Expected apply action, Got %d", action)
+	}
 }
 
-func TestWriteChangeSetToFile(t *testing.T) {
-	var testMap RepoMap
-
-	// re init globals
-	changeSet = ChangeSet{}
-	mockRepo.Remotes = nil
-	mockRemoteURLs = nil
-
-	filename := "test-" + defaultPlanFile
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL1)
-	mockRemoteURLs = append(mockRemoteURLs, remoteURL2)
-	mockRepo.Remotes = append(mockRepo.Remotes, Remote{Name: "origin", URLs: mockRemoteURLs})
-	testMap.Repos = append(testMap.Repos, mockRepo)
-
-	diffTestChangeSet := createChangeSetFromMap(testMap)
+func TestTUIUnpushedWork(t *testing.T) {
+	change := grout.RemoteChange{Name: "origin", CurrentURLs: []string{remoteURL1}, NewURLs: []string{remoteURL2},
+		SSHAlias: &grout.SSHAlias{Alias: "work", HostName: "github.com"}}
+	m := newTUIModel()
+	m.setChangeSet(grout.ChangeSet{
+		Count: 2,
+		Plans: []grout.RepoPlan{
+			{Repo: grout.LocalRepository{Name: "api", Path: "/src/api/.git"}, Changes: []grout.RemoteChange{change},
+				UnpushedWork: &grout.UnpushedWork{Stashes: 1}},
+			{Repo: grout.LocalRepository{Name: "web", Path: "/src/web/.git"}, Changes: []grout.RemoteChange{change}},
+		},
+		Skipped: []grout.SkippedRepository{{Name: "docs", Path: "/src/docs/.git", Reason: "opted out"}},
+		Broken:  []grout.BrokenRemote{{Name: "old", Path: "/src/old/.git", Remote: "origin", Reason: "unparseable"}},
+	})
+	if !m.set.Plans[0].Excluded || m.set.Count != 1 {
+		t.Errorf("Expected api to start unselected, Got count %d", m.set.Count)
+	}
+	view := strings.Join(m.view(), "\n")
+	for _, want := range []string{"! unpushed: 1 stash", "[1 skipped repo(s)]", "[1 broken remote(s)]", "(ssh alias work -> github.com)"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected the tree to show %q, Got\n%s", want, view)
+		}
+	}
 
-	if diffTestChangeSet.Count != 2 {
-		t.Errorf("diffTestChangeSet.Count: Expected 2, Got %d", diffTestChangeSet.Count)
+	// selecting api means the apply has to be confirmed with y
+	m.handleKey(keySpace)
+	m.handleKey("a")
+	if action := m.handleKey(keyEnter); action != actionNone || m.confirmed {
+		t.Errorf("Expected enter not to apply unpushed work, Got action %d", action)
 	}
-	writeChangeSetToFile(diffTestChangeSet, filename)
-	_, err := os.Open(filename)
-	if err != nil {
-		t.Error(err)
+	if !strings.Contains(strings.Join(m.view(), "\n"), "api  1 stash") {
+		t.Errorf("Expected a warning naming api, Got\n%s", strings.Join(m.view(), "\n"))
 	}
-	err = os.Remove(filename)
-	if err != nil {
-		t.Error("Error cleaning up " + filename + " after test")
+	if action := m.handleKey("y"); action != actionApply {
+		t.Errorf("Expected y to apply, Got action %d", action)
 	}
 }
 
-func TestInitPlanFromFile(t *testing.T) {
-	filename := "../test/test-" + defaultPlanFile
-	_, err := os.Open(filename)
-	if err != nil {
-		t.Error(err)
+func TestReadKey(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("a\x1b[A\x1b[Z\r\x7f\x03"))
+	expected := []string{"a", keyUp, keyBackTab, keyEnter, keyBackspace, keyCtrlC}
+	for _, want := range expected {
+		got, err := readKey(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Expected key %q, Got %q", want, got)
+		}
 	}
-	result, err := initPlanFromFile(filename)
+}
+
+func TestLoadProfile(t *testing.T) {
+	defer viper.Reset()
+	defer func() { profileName, activeProfile, targetRemoteURL, newRemoteURL = "", nil, "github.com", "gitlab.com" }()
+
+	cfg := filepath.Join(t.TempDir(), "grout.yaml")
+	err := ioutil.WriteFile(cfg, []byte(`
+set-url: top.example.com
+find-org: top-org
+profiles:
+  work:
+    set-url: gitlab.example.com
+    exclude: [vendored-*]
+`), 0644)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	if reflect.TypeOf(result) != reflect.TypeOf(ChangeSet{}) {
-		t.Error(err)
+	viper.SetConfigFile(cfg)
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+	viper.SetDefault(keyFindURL, defaultTargetHostname)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
 	}
-	if result.Count != 1 {
-		t.Error(err)
-	}
-}
+	t.Setenv(envName(keyFindOrg), "env-org")
 
-func TestInitPlanFromBlankFile(t *testing.T) {
-	filename := "../test/test-blank-file.json"
-	_, err := os.Open(filename)
-	if err != nil {
-		t.Error(err)
+	profileName = "work"
+	if err := loadProfile(); err != nil {
+		t.Fatal(err)
 	}
-	result, err := initPlanFromFile(filename)
-	if err == nil {
-		t.Error("Expected ERROR when initializing blank file")
+	if err := applyConfig(); err != nil {
+		t.Fatal(err)
 	}
-	if reflect.TypeOf(result) != reflect.TypeOf(ChangeSet{}) {
-		t.Error(err)
+	if newRemoteURL != "gitlab.example.com" || configSource(keySetURL) != "profile work" {
+		t.Errorf("Expected set-url from profile, Got %s from %s", newRemoteURL, configSource(keySetURL))
+	}
+	if targetOrganization != "env-org" || configSource(keyFindOrg) != sourceEnv {
+		t.Errorf("Expected find-org from env, Got %s from %s", targetOrganization, configSource(keyFindOrg))
+	}
+	if targetRemoteURL != defaultTargetHostname || configSource(keyFindURL) != sourceDefault {
+		t.Errorf("Expected default find-url, Got %s from %s", targetRemoteURL, configSource(keyFindURL))
+	}
+	if exclude := scanOptions(nil).Exclude; !reflect.DeepEqual(exclude, []string{"vendored-*"}) {
+		t.Errorf("Expected exclude patterns from profile, Got %v", exclude)
 	}
+
+	profileName = "missing"
+	if err := loadProfile(); err == nil {
+		t.Error("Expected ERROR when loading a missing profile")
+	}
+	excludePatterns = nil
+	targetOrganization = ""
 }
 
-func TestExecuteChangeNoChange(t *testing.T) {
-	directoryName, err := os.Getwd()
+func TestReadGitConfigSettings(t *testing.T) {
+	dir := t.TempDir()
+	global := filepath.Join(dir, "global")
+	local := filepath.Join(dir, "local")
+	err := ioutil.WriteFile(global, []byte("[grout]\n\thostname = github.com\n\tsetUrl = gitlab.com\n\torgMap = old=new\n\texclude = vendored-*\n"), 0644)
 	if err != nil {
-		t.Errorf("error getting current working directory: %v", err)
+		t.Fatal(err)
 	}
-
-	currentPath, err := filepath.Abs(directoryName)
+	err = ioutil.WriteFile(local, []byte("[grout]\n\tset-url = gitlab.example.com\n\torgmap = legacy = platform\n"), 0644)
 	if err != nil {
-		if err != nil {
-			t.Errorf("error building abs path for current dir: %v", err)
-		}
+		t.Fatal(err)
 	}
 
-	_, _ = git.PlainInit(currentPath, true)
+	settings, sources, err := readGitConfigSettings([]gitConfigFile{
+		{Scope: scopeSystem, Path: filepath.Join(dir, "missing")},
+		{Scope: scopeGlobal, Path: global},
+		{Scope: scopeLocal, Path: local},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings[keyFindURL] != "github.com" || settings[keySetURL] != "gitlab.example.com" {
+		t.Errorf("Expected local set-url to override global, Got %v", settings)
+	}
+	expectedMap := map[string]string{"old": "new", "legacy": "platform"}
+	if !reflect.DeepEqual(settings[keyOrgMap], expectedMap) {
+		t.Errorf("Expected org maps from both scopes, Got %v", settings[keyOrgMap])
+	}
+	if !reflect.DeepEqual(settings[keyExclude], []string{"vendored-*"}) {
+		t.Errorf("Expected exclude list, Got %v", settings[keyExclude])
+	}
+	if sources[keySetURL] != "git config local "+local {
+		t.Errorf("Unexpected source for set-url: %s", sources[keySetURL])
+	}
+}
 
-	localRepo := LocalRepository{
-		Name:    directoryName,
-		Path:    currentPath,
-		Remotes: nil,
+func TestGitConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := git.PlainInit(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
 	}
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, "global"))
 
-	repoPlan := RepoPlan{
-		Repo:       localRepo,
-		Changes:    nil,
-		HasChanges: false,
+	expected := []gitConfigFile{
+		{Scope: scopeGlobal, Path: filepath.Join(dir, "global")},
+		{Scope: scopeLocal, Path: filepath.Join(dir, grout.DotGit, "config")},
 	}
+	if files := gitConfigFiles(nested); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, Got %v", expected, files)
+	}
+}
 
-	var plans []RepoPlan
-	plans = append(plans, repoPlan)
 
-	set := ChangeSet{
-		Count: 1,
-		Plans: plans,
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "policy.yaml")
+	err := ioutil.WriteFile(good, []byte(`
+deprecated-hosts: [git.old.example.com]
+allowed-hosts: [github.com]
+allow-http: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	err = executeChanges(set)
+	policy, err := loadPolicy(good)
 	if err != nil {
-		t.Errorf("error executing ChangeSet w/ no changes in plan: %v", err)
+		t.Fatal(err)
+	}
+	want := grout.Policy{DeprecatedHosts: []string{"git.old.example.com"}, AllowedHosts: []string{"github.com"}, AllowHTTP: true}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("Expected %+v, Got %+v", want, policy)
 	}
-}
-
-// TODO: fix workspace cleanup to avoid failures on subsequent runs requiring mock repo creation
-//func TestExecuteChange(t *testing.T) {
-//	directoryName, err := os.Getwd()
-//	if err != nil {
-//		t.Errorf("error getting current working directory: %v", err)
-//	}
-//
-//	currentPath, err := filepath.Abs(directoryName)
-//	if err != nil {
-//		if err != nil {
-//			t.Errorf("error building abs path for current dir: %v", err)
-//		}
-//	}
-//
-//	repo, _ := git.PlainInit(currentPath, false)
-//
-//	var urls []string
-//	urls = append(urls, remoteURL1)
-//
-//	remoteConfig := config.RemoteConfig{
-//		Name: "origin",
-//		URLs: urls,
-//	}
-//
-//	_, err = repo.CreateRemote(&remoteConfig)
-//	if err != nil {
-//		t.Errorf("error creating remote for test repo: %v", err)
-//	}
-//
-//	remote := Remote{
-//		Name: remoteConfig.Name,
-//		URLs: urls,
-//	}
-//
-//	var newUrls []string
-//	newUrls = append(newUrls, remoteURL2)
-//
-//	var remotes []Remote
-//	remotes = append(remotes, remote)
-//
-//	localRepo := LocalRepository{
-//		Name: directoryName,
-//		Path: "/"+directoryName,
-//		Remotes: remotes,
-//	}
-//
-//	change := RemoteChange{
-//		Name: remotes[0].Name,
-//		Organization: "OldOrganization",
-//		CurrentURLs: remotes[0].URLs,
-//		NewURLs: newUrls,
-//	}
-//
-//	var changes []RemoteChange
-//	changes = append(changes, change)
-//
-//	repoPlan := RepoPlan{
-//		Repo: localRepo,
-//		Changes: changes,
-//		HasChanges: false,
-//	}
-//
-//	var plans []RepoPlan
-//	plans = append(plans, repoPlan)
-//
-//	set := ChangeSet{
-//		Count: 1,
-//		Plans: plans,
-//	}
-//
-//	err = executeChanges(set)
-//	if err != nil {
-//		t.Errorf("error executing ChangeSet in plan: %v", err)
-//	}
-//
-//	err = repo.DeleteRemote(remoteConfig.Name)
-//	if err != nil {
-//		t.Errorf("error deleting test remote: %v", err)
-//	}
-//}
 
-func TestTimeSlashURL(t *testing.T) {
-	url := "/this/is/a/path/"
-	url = trimSlashSuffix(url)
-	if strings.HasSuffix(url, "/") {
-		t.Error()
+	misspelt := filepath.Join(dir, "misspelt.yaml")
+	if err := ioutil.WriteFile(misspelt, []byte("deprecated-host: [git.old.example.com]\n"), 0644); err != nil {
+		t.Fatal(err)
 	}
-	url = trimSlashSuffix(url)
-	if !strings.HasSuffix(url, "h") {
-		t.Error()
+	if _, err := loadPolicy(misspelt); err == nil {
+		t.Error("Expected an error for an unknown policy key")
+	}
+	if _, err := loadPolicy(""); err == nil {
+		t.Error("Expected an error without a policy file")
 	}
 }
 
-func TestCleanPathElement(t *testing.T) {
-	if runtime.GOOS == Darwin || runtime.GOOS == Linux {
-		element := "/OldOrganization/"
-		element = removePathSeperators(element)
-		if strings.Contains(element, DarwinLinuxSep) {
-			t.Error()
+func TestAuditExitCodes(t *testing.T) {
+	defer func() { targetDirs, searchDirNames, errorBundle = nil, nil, ErrorBundle{} }()
+	repo := func(path, url string) {
+		r, err := git.PlainInit(path, false)
+		if err != nil {
+			t.Fatal(err)
 		}
-	} else if runtime.GOOS == Windows {
-		element := "\\OldOrganization\\"
-		element = removePathSeperators(element)
-		if strings.Contains(element, WindowsSep) {
-			t.Error()
+		if _, err := r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{url}}); err != nil {
+			t.Fatal(err)
 		}
 	}
-
-}
-
-func TestVerifyTargetDirIsAbs(t *testing.T) {
-	targetDir = "/this/is/an/abs/path"
-	err := verifyTargetDirIsAbs()
-	if err != nil {
-		t.Error()
+	clean, broken, violating := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, dir := range []string{clean, broken, violating} {
+		repo(filepath.Join(dir, "api"), remoteURL1)
 	}
-}
-
-func TestVerifyTargetDirIsNotAbs(t *testing.T) {
-	targetDir = "this/is/NOT/an/abs/path"
-	err := verifyTargetDirIsAbs()
-	if err == nil {
-		t.Error()
+	for _, dir := range []string{broken, violating} {
+		repo(filepath.Join(dir, "unreadable"), remoteURL1)
+		err := ioutil.WriteFile(filepath.Join(dir, "unreadable", grout.DotGit, "config"), []byte("[core"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo(filepath.Join(violating, "legacy"), "https://git.old.example.com/org/legacy.git")
+
+	policy := grout.Policy{DeprecatedHosts: []string{"git.old.example.com"}}
+	tests := []struct {
+		dir        string
+		code       int
+		violations int
+	}{
+		{clean, 0, 0},
+		{broken, auditExitError, 0},
+		// violations are still reported when some repos can't be read
+		{violating, auditExitViolations, 1},
+	}
+	for _, test := range tests {
+		targetDirs, searchDirNames, errorBundle = []string{test.dir}, nil, ErrorBundle{}
+		violations, code, err := auditRepositories(context.Background(), policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code || len(violations) != test.violations {
+			t.Errorf("%s: Expected exit %d with %d violation(s), Got exit %d with %v", test.dir, test.code, test.violations, code, violations)
+		}
 	}
 }
@@ -1,18 +1,15 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
+	"github.com/JoshRodstein/grout/pkg/grout"
 )
 
 // Some of these constants are silly, but I like them
@@ -29,8 +26,6 @@ const (
 	WindowsSep     = "\\"
 	DarwinLinuxSep = "/"
 
-	dotGit    = ".git"
-	justGit   = "git"
 	http      = "http"
 	https     = "https"
 	twoSpaces = "  "
@@ -41,257 +36,193 @@ const (
 
 // Global package variables
 var cfgFile string
-var targetDir string
-var parentDir string
+var targetDirs []string
+var searchDirNames []string
+var reposFrom string
+var repoListPaths []string
 var remoteType string
 var targetOrganization string
 var newOrganization string
 var newRemoteURL string
 var targetRemoteURL string
 
-var repoMap RepoMap
-var changeSet ChangeSet
+var forcedRepos []string
+
 var errorBundle ErrorBundle
 
-// Structs to aid in mapping, planning, and executing changes
+// Errors collected while planning or applying, reported once a command
+// has finished
 type ErrorBundle struct {
 	Count  int
 	Errors []error
 }
 
-type SplitUrl struct {
-	Type    string
-	BaseURL string
-	Org     string
-	Repo    string
-}
-
-type Remote struct {
-	Name string   `json:"name"`
-	URLs []string `json:"urls"`
-}
-
-type LocalRepository struct {
-	Name    string   `json:"name"`
-	Path    string   `json:"path"`
-	Remotes []Remote `json:"remotes"`
+func (b *ErrorBundle) add(errs ...error) {
+	b.Count += len(errs)
+	b.Errors = append(b.Errors, errs...)
 }
 
-type RepoMap struct {
-	Meta  map[string]string `json:"meta"`
-	Repos []LocalRepository `json:"repos"`
-}
-
-// Change structs represent changes to a repo's remotes
-type RemoteChange struct {
-	Name         string   `json:"name"`
-	Organization string   `json:"newOrganization"`
-	CurrentURLs  []string `json:"current_urls"`
-	NewURLs      []string `json:"new_urls"`
+// Scan options built from the command line and configuration
+func scanOptions(progress func(path string, info os.FileInfo, found int)) grout.ScanOptions {
+	return grout.ScanOptions{
+		Directories:    targetDirs,
+		DirectoryNames: searchDirNames,
+		RepoPaths:      repoListPaths,
+		ForcedRepos:    forcedRepos,
+		Exclude:        excludePatterns,
+		Progress:       progress,
+		Backend:        backend,
+	}
 }
 
-type RepoPlan struct {
-	Repo       LocalRepository `json:"repo"`
-	Changes    []RemoteChange  `json:"changes"`
-	HasChanges bool            `json:"has_changes"`
+// Plan options built from the command line and configuration
+func planOptions() grout.PlanOptions {
+	return grout.PlanOptions{
+		FindURL:    targetRemoteURL,
+		SetURL:     newRemoteURL,
+		FindOrg:    targetOrganization,
+		SetOrg:     newOrganization,
+		RemoteType: remoteType,
+		OrgMap:     orgMap,
+		Rules:      migrationRules,
+
+		CheckUnpushed:     true,
+		ExcludeUnpushed:   excludeUnpushed,
+		StripCredentials:  stripCredentials,
+		GitSuffix:         gitSuffix,
+		NormalizeOnly:     normalizeOnly,
+		UpgradeProtocol:   upgradeProtocol,
+		GitProtocolTo:     gitProtocolTo,
+		SSHConfig:         sshConfig,
+		ReplaceSSHAliases: sshAliases == sshAliasesReplace,
+		Backend:           backend,
+	}
 }
 
-type ChangeSet struct {
-	Count int        `json:"count"`
-	Plans []RepoPlan `json:"plans"`
+// Search for repos with the current parameters. Problems with single repos
+// are added to the error bundle
+func searchForRepositories(ctx context.Context, progress func(path string, info os.FileInfo, found int)) (grout.RepoMap, error) {
+	repoMap, err := grout.NewScanner(scanOptions(progress)).Scan(ctx)
+	errorBundle.add(repoMap.Errors...)
+	return repoMap, err
 }
 
-// Build a new remote url string if the given remote matches our
-// defaultTargetRemoteURL
-func createNewRemoteURLs(urls []string, set *ChangeSet) []string {
-	var newRemoteURLs []string
-
-	for _, url := range urls {
-		fields := strings.FieldsFunc(url, UrlSplit)
-		if len(fields) != 4 {
-			// Malformed Remote URL, skipping
-			continue
-		}
-		splitUrl := SplitUrl{
-			Type:    fields[0],
-			BaseURL: fields[1],
-			Org:     fields[2],
-			Repo:    fields[3],
-		}
-		if splitUrl.BaseURL == targetRemoteURL {
-			if len(targetOrganization) > 0 && targetOrganization != splitUrl.Org {
-				newRemoteURLs = append(newRemoteURLs, url)
-			} else {
-				if len(newOrganization) > 0 {
-					splitUrl.Org = newOrganization
-				}
-
-				var newRemote string
-
-				if splitUrl.Type == justGit {
-					newRemote = fmt.Sprintf("%s@%s:%s/%s", splitUrl.Type, newRemoteURL, splitUrl.Org, splitUrl.Repo)
-				} else if strings.Contains(splitUrl.Type, http) {
-					newRemote = fmt.Sprintf("%s://%s/%s/%s", remoteType, newRemoteURL, splitUrl.Org, splitUrl.Repo)
-				}
-				newRemoteURLs = append(newRemoteURLs, newRemote)
-
-				set.Count++
-			}
-		} else {
-			newRemoteURLs = append(newRemoteURLs, url)
-		}
+// This generates a git remote change set for a given map of Repos
+func createChangeSetFromMap(ctx context.Context, repoMap grout.RepoMap) grout.ChangeSet {
+	set, err := grout.NewPlanner(planOptions()).Plan(ctx, repoMap)
+	errorBundle.add(set.Errors...)
+	if err != nil {
+		errorBundle.add(err)
 	}
-	return newRemoteURLs
+	return set
 }
 
-// Searches for a git repo in a given directory path
-// If found, the repo is added to the RepoMap
-func mapRepository(path string, info os.FileInfo, err error, repoMap *RepoMap) error {
+func executeChanges(ctx context.Context, set grout.ChangeSet) error {
+	run, err := startBackupRun()
 	if err != nil {
-		errorBundle.Count += 1
-		errorBundle.Errors = append(errorBundle.Errors, err)
-		return nil
+		fmt.Println(err)
+		return err
 	}
-	if info.Name() == dotGit {
-		r, err := git.PlainOpen(path)
-		if err != nil {
-			fmt.Printf("Error opening repo: %v\n", err)
-			return err
-		}
-
-		remotes, err := r.Remotes()
-		if err != nil {
-			fmt.Println(err)
-			return err
-		}
-
-		var mappedRemotes []Remote
-		for _, remote := range remotes {
-			mappedRemotes = append(mappedRemotes, Remote{
-				Name: remote.Config().Name,
-				URLs: remote.Config().URLs,
-			})
-		}
-
-		currentRepo := LocalRepository{
-			Name:    parentDir,
-			Path:    path,
-			Remotes: mappedRemotes,
-		}
-		repoMap.Repos = append(repoMap.Repos, currentRepo)
-
-	} else if info.IsDir() {
-		parentDir = info.Name()
+	err = grout.NewApplier(grout.ApplyOptions{Backend: backend, Backups: run}).Apply(ctx, set)
+	var locked *grout.LockedReposError
+	if errors.As(err, &locked) {
+		DisplayLockedRepositories(locked.Paths)
+	} else if err != nil {
+		fmt.Println(err)
 	}
-	return nil
+	if run != nil && len(run.Entries) > 0 {
+		fmt.Printf("Original configs were backed up to run %s. Undo with: grout restore %s\n", run.ID, run.ID)
+	}
+	return err
 }
 
-// This generates a git remote change set for a given map of Repos
-func createChangeSetFromMap(repoMap RepoMap) ChangeSet {
-	for _, repo := range repoMap.Repos {
-
-		var plan RepoPlan
-		var changePlan RepoPlan
-
-		changePlan.HasChanges = false
-		plan.Repo = repo
-
-		for _, remote := range repo.Remotes {
-			currentURLs := remote.URLs
-			newURLs := createNewRemoteURLs(currentURLs, &changeSet)
-			for i := 0; i < len(newURLs); i++ {
-				if currentURLs[i] == newURLs[i] {
-					continue
-				} else {
-					change := RemoteChange{
-						Name: remote.Name,
-					}
-					change.CurrentURLs = append(change.CurrentURLs, currentURLs[i])
-					change.NewURLs = append(change.NewURLs, newURLs[i])
-
-					changePlan.Changes = append(changePlan.Changes, change)
-					changePlan.HasChanges = true
-				}
-			}
-		}
-		if changePlan.HasChanges {
-			changePlan.Repo = plan.Repo
-			changeSet.Plans = append(changeSet.Plans, changePlan)
-		}
+// Start a backup run for an apply, or return nil if --no-backup is set
+func startBackupRun() (*grout.BackupRun, error) {
+	if noBackup {
+		return nil, nil
+	}
+	run, err := grout.BackupStore{Dir: backupDir}.NewRun(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("unable to start a backup run, use --no-backup to apply without one: %w", err)
 	}
-	return changeSet
+	return run, nil
 }
 
 // Write all of our calculated changes to a json file in the current dir
-func writeChangeSetToFile(changes ChangeSet, filename string) {
-	jsonStr, err := json.MarshalIndent(changes, "", twoSpaces)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	err = ioutil.WriteFile(filename, jsonStr, os.ModePerm)
-	if err != nil {
-		fmt.Println(err)
-		return
+func writeChangeSetToFile(changes grout.ChangeSet, filename string) error {
+	if err := grout.WritePlanFile(changes, filename); err != nil {
+		return fmt.Errorf("Unable to save plan to %s: %s", filename, err)
 	}
+	return nil
 }
 
 // Initialize json file into a ChangeSet
-func initPlanFromFile(fd string) (ChangeSet, error) {
+func initPlanFromFile(fd string) (grout.ChangeSet, error) {
 	fmt.Println("\nInitializing plan...")
-	jsonFile, err := os.Open(fd)
-	if err != nil {
+	set, err := grout.ReadPlanFile(fd)
+	if os.IsNotExist(err) {
 		fmt.Printf("Unable to open plan file")
-		return ChangeSet{}, err
-	}
-	byteValue, err := ioutil.ReadAll(jsonFile)
-	if err != nil {
-		fmt.Printf("Error reading json: %s\n", err)
-	}
-	byteValue = bytes.ReplaceAll(byteValue, []byte(twoSpaces), []byte(""))
-	var set ChangeSet
-	err = json.Unmarshal([]byte(byteValue), &set)
-	if err != nil {
+		return grout.ChangeSet{}, err
+	} else if err != nil {
 		fmt.Println("Error unmarshalling plan from file. Try recreating the plan before running update again.")
-		return ChangeSet{}, err
+		return grout.ChangeSet{}, err
 	}
 	return set, nil
 }
 
-func executeChanges(set ChangeSet) error {
-	for _, plan := range set.Plans {
-		repoPath := plan.Repo.Path
-		gitRepo, err := git.PlainOpen(repoPath)
+// Read the --repos-from list, if one was given, and resolve the search
+// directories. A repo list replaces the search unless directories were
+// also given on the command line
+func prepareSearch(dirsChanged bool) error {
+	if len(reposFrom) > 0 {
+		paths, err := loadRepoList(reposFrom)
 		if err != nil {
-			fmt.Println(err)
-			return err
+			return fmt.Errorf("Unable to read repo list: %s", err)
 		}
-		for _, change := range plan.Changes {
-			err := updateRemote(&change, gitRepo)
-			if err != nil {
-				fmt.Println(err)
-				return err
-			}
+		repoListPaths = paths
+		if !dirsChanged {
+			targetDirs = nil
 		}
 	}
+	if err := resolveTargetDirs(); err != nil {
+		return fmt.Errorf("\n%s - Aborting", err)
+	}
 	return nil
 }
 
-func updateRemote(change *RemoteChange, gitRepo *git.Repository) error {
-	remoteName := change.Name
-	err := gitRepo.DeleteRemote(remoteName)
+// Load the --repos-from list from a file, or stdin if the file is "-"
+func loadRepoList(filename string) ([]string, error) {
+	if filename == "-" {
+		return grout.ReadRepoList(os.Stdin)
+	}
+	f, err := os.Open(filename)
 	if err != nil {
-		fmt.Printf("Error deleting remote: %s\n", err)
-		return err
+		return nil, err
 	}
-	remoteConfig := config.RemoteConfig{
-		Name: remoteName,
-		URLs: change.NewURLs,
+	defer f.Close()
+	return grout.ReadRepoList(f)
+}
+
+// Split a comma separated list of directories, dropping empty entries
+func splitDirectoryList(value string) []string {
+	var dirs []string
+	for _, dir := range strings.Split(value, ",") {
+		if dir = strings.TrimSpace(dir); len(dir) > 0 {
+			dirs = append(dirs, dir)
+		}
 	}
-	if _, err = gitRepo.CreateRemote(&remoteConfig); err != nil {
-		fmt.Printf("Error creating remote: %s\n", err)
+	return dirs
+}
+
+// Normalize every search directory in place, checking that each one exists.
+// The directories as they were given are kept in searchDirNames
+func resolveTargetDirs() error {
+	dirs, err := grout.ResolveDirectories(targetDirs)
+	if err != nil {
 		return err
 	}
+	searchDirNames = append([]string{}, targetDirs...)
+	copy(targetDirs, dirs)
 	return nil
 }
 
@@ -312,15 +243,11 @@ func removePathSeperators(str string) string {
 	return str
 }
 
-func UrlSplit(r rune) bool {
-	return r == ':' || r == '/' || r == '@'
-}
-
-func verifyTargetDirIsAbs() error {
-	if !filepath.IsAbs(targetDir) {
-		fmt.Printf("\nInvalid parameter: %s \n"+
-			"Absolute path is required for search directory - Aborting\n", targetDir)
-		return errors.New("invalid parameter")
+// Count the distinct repos listed in an inventory
+func countInventoryRepos(inventory grout.Inventory) int {
+	repos := map[string]bool{}
+	for _, entry := range inventory.Entries {
+		repos[entry.Path] = true
 	}
-	return nil
+	return len(repos)
 }
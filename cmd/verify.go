@@ -0,0 +1,94 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/JoshRodstein/grout/pkg/grout"
+	"github.com/spf13/cobra"
+)
+
+var verifyAfterUpdate bool
+var verifyFormat string
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the new urls in a plan exist and match the old remotes",
+	Long: `
+Check that the new urls in a plan exist and match the old remotes:
+
+  Run ls-remote against every new url in a plan and compare the branches it
+  advertises with the remote-tracking branches fetched from the old url.
+  Reports urls whose repo is missing, that refuse authentication, that
+  can't be reached, or whose branches don't match. Nothing is modified.
+  Exits non-zero if any url fails verification.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if verifyFormat != outputText && verifyFormat != outputJSON {
+			fmt.Printf("Unknown output format: %s\n", verifyFormat)
+			os.Exit(1)
+		}
+		set, err := grout.ReadPlanFile(planFile)
+		if err != nil {
+			fmt.Printf("Unable to read plan file: %s\n", err)
+			os.Exit(1)
+		}
+		if !verifyChanges(cmd.Context(), set) {
+			os.Exit(1)
+		}
+	},
+}
+
+// Verify the new urls of a ChangeSet, printing each result as it completes.
+// Returns false if any url failed verification
+func verifyChanges(ctx context.Context, set grout.ChangeSet) bool {
+	progress := DisplayURLVerification
+	if verifyFormat == outputJSON {
+		progress = nil
+	}
+	results, err := grout.NewVerifier(grout.VerifyOptions{Backend: backend, Progress: progress}).Verify(ctx, set)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	if verifyFormat == outputJSON {
+		jsonStr, err := json.MarshalIndent(results, "", twoSpaces)
+		if err != nil {
+			fmt.Println(err)
+			return false
+		}
+		fmt.Println(string(jsonStr))
+	} else {
+		DisplayVerificationSummary(results)
+	}
+	for _, result := range results {
+		if result.Status != grout.VerifyOK {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVarP(&planFile, "file", "f", defaultPlanFile, "Target a plan file")
+	verifyCmd.Flags().StringVarP(&verifyFormat, "output", "o", outputText, "Output format: text or json")
+}
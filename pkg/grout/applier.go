@@ -0,0 +1,204 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultLockRetries = 5
+	defaultLockBackoff = 100 * time.Millisecond
+)
+
+// How an Applier works through a ChangeSet
+type ApplyOptions struct {
+	// Keep applying the remaining plans after one fails. Repos whose config
+	// stays locked never stop the apply
+	ContinueOnError bool
+	// Called after each plan is applied, with the error if it failed
+	Progress func(plan RepoPlan, err error)
+	// Writes the changes to each repo. Defaults to go-git
+	Backend Backend
+	// Where each repo's config is copied before it is changed. Nothing is
+	// backed up if nil
+	Backups *BackupRun
+	// How many times a locked config is retried, doubling the wait from
+	// LockBackoff each time. They default to 5 and 100ms; a negative number of
+	// retries disables them
+	LockRetries int
+	LockBackoff time.Duration
+}
+
+// An Applier writes planned remote changes to each repo's config
+type Applier struct {
+	opts ApplyOptions
+	// grout.lock of each repo held for the whole of Apply
+	held map[string]*applyLock
+}
+
+func NewApplier(opts ApplyOptions) *Applier {
+	opts.Backend = backendOrDefault(opts.Backend)
+	if opts.LockRetries == 0 {
+		opts.LockRetries = defaultLockRetries
+	}
+	if opts.LockBackoff <= 0 {
+		opts.LockBackoff = defaultLockBackoff
+	}
+	return &Applier{opts: opts}
+}
+
+// Apply every plan in the ChangeSet, leaving out excluded repos and
+// changes. Every repo is locked against other grout applies before any is
+// changed. Returns the first error, after finishing the remaining plans if
+// ContinueOnError is set. Repos whose config stayed locked are skipped and
+// reported with a LockedReposError once the others are applied
+func (a *Applier) Apply(ctx context.Context, set ChangeSet) error {
+	if err := a.lockAll(set); err != nil {
+		return err
+	}
+	defer a.releaseAll()
+
+	var firstErr error
+	var locked []string
+	for _, plan := range set.Plans {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if plan.Excluded {
+			continue
+		}
+		err := a.ApplyPlan(ctx, plan)
+		if a.opts.Progress != nil {
+			a.opts.Progress(plan, err)
+		}
+		if errors.Is(err, ErrConfigLocked) {
+			locked = append(locked, plan.Repo.Path)
+			continue
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err != nil && !a.opts.ContinueOnError {
+			return err
+		}
+	}
+	if firstErr == nil && len(locked) > 0 {
+		return &LockedReposError{Paths: locked}
+	}
+	return firstErr
+}
+
+// Apply the changes planned for a single repo. Each current url is replaced
+// by its new url in place, so urls and settings the plan doesn't mention
+// are kept. The repo's config is backed up first if Backups is set
+func (a *Applier) ApplyPlan(ctx context.Context, plan RepoPlan) (err error) {
+	if !hasChanges(plan) {
+		return nil
+	}
+	if _, ok := a.held[plan.Repo.Path]; !ok {
+		lock, err := lockApply(plan.Repo.Path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", plan.Repo.Path, err)
+		}
+		defer lock.release()
+	}
+	if plan, err = unredactPlan(ctx, a.opts.Backend, plan); err != nil {
+		return fmt.Errorf("%s: %w", plan.Repo.Path, err)
+	}
+
+	if a.opts.Backups != nil {
+		if err := a.opts.Backups.Backup(plan.Repo); err != nil {
+			return err
+		}
+		defer func() {
+			if appliedErr := a.opts.Backups.Applied(plan.Repo); appliedErr != nil && err == nil {
+				err = fmt.Errorf("%s: %w", plan.Repo.Path, appliedErr)
+			}
+		}()
+	}
+	for _, change := range plan.Changes {
+		if change.Excluded {
+			continue
+		}
+		for i := 0; i < len(change.CurrentURLs) && i < len(change.NewURLs); i++ {
+			err := a.replaceURL(ctx, plan.Repo.Path, change.Name, change.CurrentURLs[i], change.NewURLs[i])
+			if err != nil {
+				return fmt.Errorf("%s: %w", plan.Repo.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Replace a url, waiting with backoff while another process holds the
+// repo's config.lock
+func (a *Applier) replaceURL(ctx context.Context, gitDir, remote, currentURL, newURL string) error {
+	wait := a.opts.LockBackoff
+	for attempt := 0; ; attempt++ {
+		err := a.opts.Backend.ReplaceURL(ctx, gitDir, remote, currentURL, newURL)
+		if !errors.Is(err, ErrConfigLocked) || attempt >= a.opts.LockRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// Take the grout.lock of every repo with changes, so that another grout
+// can't apply to any of them until this apply is done
+func (a *Applier) lockAll(set ChangeSet) error {
+	a.held = map[string]*applyLock{}
+	for _, plan := range set.Plans {
+		if plan.Excluded || !hasChanges(plan) {
+			continue
+		}
+		if _, ok := a.held[plan.Repo.Path]; ok {
+			continue
+		}
+		lock, err := lockApply(plan.Repo.Path)
+		if err != nil {
+			a.releaseAll()
+			return fmt.Errorf("%s: %w", plan.Repo.Path, err)
+		}
+		a.held[plan.Repo.Path] = lock
+	}
+	return nil
+}
+
+func (a *Applier) releaseAll() {
+	for _, lock := range a.held {
+		lock.release()
+	}
+	a.held = nil
+}
+
+// Reports whether a plan has any url left to change
+func hasChanges(plan RepoPlan) bool {
+	for _, change := range plan.Changes {
+		if !change.Excluded && len(change.CurrentURLs) > 0 && len(change.NewURLs) > 0 {
+			return true
+		}
+	}
+	return false
+}
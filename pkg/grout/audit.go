@@ -0,0 +1,115 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rules an audited remote url can break
+const (
+	RuleDeprecatedHost = "deprecated-host"
+	RulePlainHTTP      = "plain-http"
+	RuleDisallowedOrg  = "disallowed-org"
+	RuleCredentials    = "embedded-credentials"
+	RuleUnknownHost    = "unknown-host"
+)
+
+// What remote urls are allowed to look like. Hosts are compared without
+// regard to case. Local paths and file urls are never audited
+type Policy struct {
+	// Hosts nobody should be using any more
+	DeprecatedHosts []string `mapstructure:"deprecated-hosts"`
+	// If set, every host that isn't listed here or deprecated is unknown
+	AllowedHosts []string `mapstructure:"allowed-hosts"`
+	// Orgs nobody should be using, either as org on any host or host/org
+	DisallowedOrgs []string `mapstructure:"disallowed-orgs"`
+	// Allow http urls. They are reported by default
+	AllowHTTP bool `mapstructure:"allow-http"`
+	// Allow passwords and tokens in urls. They are reported by default
+	AllowCredentials bool `mapstructure:"allow-credentials"`
+}
+
+// A remote url that breaks a Policy rule. A url breaking several rules
+// has one Violation for each
+type Violation struct {
+	Repo    string `json:"repo"`
+	Path    string `json:"path"`
+	Remote  string `json:"remote"`
+	URL     string `json:"url"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Check every remote url in a RepoMap against the policy. Violations are
+// sorted as BuildInventory sorts remotes
+func (p Policy) Audit(repoMap RepoMap) []Violation {
+	var violations []Violation
+	for _, entry := range BuildInventory(repoMap).Entries {
+		for _, violation := range p.check(entry) {
+			violation.Repo = entry.Repo
+			violation.Path = entry.Path
+			violation.Remote = entry.Remote
+			violation.URL = entry.URL
+			violations = append(violations, violation)
+		}
+	}
+	return violations
+}
+
+// The rules a single remote url breaks
+func (p Policy) check(entry InventoryEntry) []Violation {
+	var broken []Violation
+	add := func(rule, format string, args ...interface{}) {
+		broken = append(broken, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	if entry.Protocol == ProtocolFile {
+		return nil
+	}
+	if !p.AllowCredentials && hasCredentials(entry.rawURL) {
+		add(RuleCredentials, "url contains a password or token")
+	}
+	if !p.AllowHTTP && entry.Protocol == ProtocolHTTP {
+		add(RulePlainHTTP, "url uses plain http")
+	}
+
+	host := strings.ToLower(entry.Host)
+	switch {
+	case len(host) == 0:
+		if len(p.AllowedHosts) > 0 {
+			add(RuleUnknownHost, "url can't be split into a host, org and repo")
+		}
+	case containsFold(p.DeprecatedHosts, host):
+		add(RuleDeprecatedHost, "host %s is deprecated", entry.Host)
+	case len(p.AllowedHosts) > 0 && !containsFold(p.AllowedHosts, host):
+		add(RuleUnknownHost, "host %s is not an allowed host", entry.Host)
+	}
+
+	if len(entry.Org) > 0 && (containsFold(p.DisallowedOrgs, entry.Org) || containsFold(p.DisallowedOrgs, host+"/"+entry.Org)) {
+		add(RuleDisallowedOrg, "org %s is not allowed", entry.Org)
+	}
+	return broken
+}
+
+func containsFold(list []string, str string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, str) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,344 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Names of the available backends
+const (
+	BackendGoGit = "go-git"
+	BackendGit   = "git"
+)
+
+// A Backend reads and changes the remotes in a repo's config. gitDir is
+// the repo's .git directory, or the repo itself if it is bare
+type Backend interface {
+	// List the remotes of a repo, sorted by name
+	Remotes(ctx context.Context, gitDir string) ([]Remote, error)
+	// Replace one url of a remote, keeping its position. It is an error
+	// if the remote doesn't exist or doesn't have the current url
+	ReplaceURL(ctx context.Context, gitDir, remote, currentURL, newURL string) error
+	// List the refs a url advertises, as git ls-remote does, mapped to
+	// their hashes. Fails with ErrRemoteRepoNotFound or ErrRemoteAuthFailed
+	// when the url can't be listed for those reasons
+	LsRemote(ctx context.Context, gitDir, url string) (map[string]string, error)
+	// List a remote's remote-tracking branches, mapped to their hashes
+	TrackingRefs(ctx context.Context, gitDir, remote string) (map[string]string, error)
+	// Find local commits, branches and stashes that haven't been pushed to
+	// any of the remotes. Bare repos report none
+	UnpushedWork(ctx context.Context, gitDir string, remotes []string) (UnpushedWork, error)
+	// Fetch a remote, pruning tracking branches it no longer has, and point
+	// refs/remotes/<remote>/HEAD at its default branch. Returns that branch,
+	// or "" if the remote doesn't advertise one
+	Fetch(ctx context.Context, gitDir, remote string) (string, error)
+}
+
+var (
+	// Returned when a url doesn't lead to a repo
+	ErrRemoteRepoNotFound = errors.New("repository not found")
+	// Returned when a url's repo refuses the credentials available
+	ErrRemoteAuthFailed = errors.New("authentication failed")
+)
+
+// Look up a backend by name. An empty name selects go-git
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case "", BackendGoGit:
+		return GoGitBackend{}, nil
+	case BackendGit:
+		return ExecBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q, expected %s or %s", name, BackendGoGit, BackendGit)
+}
+
+// Use go-git if no backend was given
+func backendOrDefault(backend Backend) Backend {
+	if backend == nil {
+		return GoGitBackend{}
+	}
+	return backend
+}
+
+// GoGitBackend reads config with go-git and edits it in place, without
+// needing git to be installed. It doesn't follow include or includeIf
+// directives
+type GoGitBackend struct{}
+
+func (GoGitBackend) Remotes(ctx context.Context, gitDir string) ([]Remote, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repo %s: %w", gitDir, err)
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, fmt.Errorf("error reading config of %s: %w", gitDir, err)
+	}
+
+	var remotes []Remote
+	for name, remote := range cfg.Remotes {
+		remotes = append(remotes, Remote{Name: name, URLs: remote.URLs})
+	}
+	sort.Slice(remotes, func(i, j int) bool { return remotes[i].Name < remotes[j].Name })
+	return remotes, nil
+}
+
+// The url line is edited in place in the config file, so comments,
+// whitespace and unrelated sections are left as they were. The config is
+// locked with config.lock while it is rewritten, as git does
+func (GoGitBackend) ReplaceURL(ctx context.Context, gitDir, remote, currentURL, newURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	lock, err := lockConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		lock.rollback()
+		return fmt.Errorf("error reading config of %s: %w", gitDir, err)
+	}
+
+	edited, err := replaceConfigURL(data, remote, currentURL, newURL)
+	if err != nil {
+		lock.rollback()
+		if errors.Is(err, errRemoteNotFound) {
+			return fmt.Errorf("remote %s does not exist", remote)
+		}
+		return fmt.Errorf("remote %s no longer has url %s", remote, RedactURL(currentURL))
+	}
+	if err := lock.commit(edited); err != nil {
+		return fmt.Errorf("error writing config of %s: %w", gitDir, err)
+	}
+	return nil
+}
+
+func (GoGitBackend) LsRemote(ctx context.Context, gitDir, url string) (map[string]string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "grout", URLs: []string{url}})
+	listed, err := remote.ListContext(ctx, &git.ListOptions{})
+	switch {
+	case errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return map[string]string{}, nil
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		return nil, fmt.Errorf("%w: %s", ErrRemoteRepoNotFound, RedactURL(url))
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed):
+		return nil, fmt.Errorf("%w: %s", ErrRemoteAuthFailed, RedactURL(url))
+	case err != nil:
+		return nil, classifyLsRemoteError(url, err.Error())
+	}
+
+	refs := map[string]string{}
+	for _, ref := range listed {
+		if ref.Type() == plumbing.HashReference {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+	}
+	return refs, nil
+}
+
+func (GoGitBackend) TrackingRefs(ctx context.Context, gitDir, remote string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repo %s: %w", gitDir, err)
+	}
+	iter, err := r.References()
+	if err != nil {
+		return nil, fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+
+	prefix := "refs/remotes/" + remote + "/"
+	refs := map[string]string{}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if ref.Type() == plumbing.HashReference && strings.HasPrefix(name, prefix) && name != prefix+"HEAD" {
+			refs[strings.TrimPrefix(name, prefix)] = ref.Hash().String()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+	return refs, nil
+}
+
+// Turn an ls-remote failure into ErrRemoteRepoNotFound or
+// ErrRemoteAuthFailed when its message says that is what happened
+func classifyLsRemoteError(url, msg string) error {
+	url, msg = RedactURL(url), RedactURL(msg)
+	lower := strings.ToLower(msg)
+	for _, hint := range []string{"authentication", "permission denied", "could not read username",
+		"could not read password", "terminal prompts disabled", "ssh agent", "403"} {
+		if strings.Contains(lower, hint) {
+			return fmt.Errorf("%w: %s: %s", ErrRemoteAuthFailed, url, msg)
+		}
+	}
+	for _, hint := range []string{"not found", "does not appear to be a git repository", "does not exist", "404"} {
+		if strings.Contains(lower, hint) {
+			return fmt.Errorf("%w: %s: %s", ErrRemoteRepoNotFound, url, msg)
+		}
+	}
+	return fmt.Errorf("unable to list %s: %s", url, msg)
+}
+
+// ExecBackend runs the git binary, so config is read exactly as git reads
+// it, including include and includeIf directives
+type ExecBackend struct {
+	// The git binary to run. Defaults to git on the PATH
+	Path string
+}
+
+func (b ExecBackend) git(ctx context.Context, gitDir string, args ...string) (string, error) {
+	path := b.Path
+	if len(path) == 0 {
+		path = "git"
+	}
+	cmd := exec.CommandContext(ctx, path, append([]string{"--git-dir", gitDir}, args...)...)
+	// fail rather than wait on a credential prompt nobody will answer
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		// git's messages may quote a url with its password in it
+		if msg := RedactURL(strings.TrimSpace(stderr.String())); len(msg) > 0 {
+			if strings.Contains(msg, "could not lock config file") {
+				return stdout.String(), fmt.Errorf("%w: %s", ErrConfigLocked, msg)
+			}
+			return stdout.String(), fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return stdout.String(), fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+func (b ExecBackend) Remotes(ctx context.Context, gitDir string) ([]Remote, error) {
+	out, err := b.git(ctx, gitDir, "config", "--local", "--includes", "--null", "--get-regexp", `^remote\..*\.url$`)
+	if err != nil {
+		// git config exits 1 without output when nothing matches
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(out) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading config of %s: %w", gitDir, err)
+	}
+
+	byName := map[string]*Remote{}
+	var remotes []*Remote
+	// with --null each entry is the key, a newline, then the value
+	for _, entry := range strings.Split(out, "\x00") {
+		key, value, ok := strings.Cut(entry, "\n")
+		if !ok {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+		remote, ok := byName[name]
+		if !ok {
+			remote = &Remote{Name: name}
+			byName[name] = remote
+			remotes = append(remotes, remote)
+		}
+		remote.URLs = append(remote.URLs, value)
+	}
+
+	result := make([]Remote, 0, len(remotes))
+	for _, remote := range remotes {
+		result = append(result, *remote)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (b ExecBackend) ReplaceURL(ctx context.Context, gitDir, remote, currentURL, newURL string) error {
+	remotes, err := b.Remotes(ctx, gitDir)
+	if err != nil {
+		return err
+	}
+	for _, r := range remotes {
+		if r.Name != remote {
+			continue
+		}
+		if !containsString(r.URLs, currentURL) {
+			return fmt.Errorf("remote %s no longer has url %s", remote, RedactURL(currentURL))
+		}
+		// set-url matches the old url as a regex, so anchor and escape it
+		_, err := b.git(ctx, gitDir, "remote", "set-url", remote, newURL, "^"+regexp.QuoteMeta(currentURL)+"$")
+		return err
+	}
+	return fmt.Errorf("remote %s does not exist", remote)
+}
+
+func (b ExecBackend) LsRemote(ctx context.Context, gitDir, url string) (map[string]string, error) {
+	out, err := b.git(ctx, gitDir, "ls-remote", url)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, classifyLsRemoteError(url, strings.TrimPrefix(err.Error(), "git ls-remote: "))
+	}
+	refs := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		hash, name, ok := strings.Cut(line, "\t")
+		// peeled tags (name^{}) point at the tagged commit, not a ref
+		if ok && !strings.HasSuffix(name, "^{}") {
+			refs[name] = hash
+		}
+	}
+	return refs, nil
+}
+
+func (b ExecBackend) TrackingRefs(ctx context.Context, gitDir, remote string) (map[string]string, error) {
+	prefix := "refs/remotes/" + remote + "/"
+	out, err := b.git(ctx, gitDir, "for-each-ref", "--format=%(objectname) %(symref) %(refname)", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+	refs := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		// symbolic refs such as <remote>/HEAD have a target and are skipped
+		if len(fields) != 2 {
+			continue
+		}
+		refs[strings.TrimPrefix(fields[1], prefix)] = fields[0]
+	}
+	return refs, nil
+}
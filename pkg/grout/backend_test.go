@@ -0,0 +1,165 @@
+package grout
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5/config"
+)
+
+// Every backend must behave the same on the repos grout supports
+func backendsUnderTest(t *testing.T) map[string]Backend {
+	backends := map[string]Backend{BackendGoGit: GoGitBackend{}}
+	if _, err := exec.LookPath("git"); err == nil {
+		backends[BackendGit] = ExecBackend{}
+	} else {
+		t.Log("git is not installed, only testing go-git")
+	}
+	// keep the user's own git config out of the tests
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	return backends
+}
+
+func conformanceRepo(t *testing.T, bare bool) string {
+	path := filepath.Join(t.TempDir(), "repo.git")
+	initRepo(t, path, bare,
+		config.RemoteConfig{Name: "upstream", URLs: []string{remoteURL3}},
+		config.RemoteConfig{Name: "origin", URLs: []string{remoteURL1, remoteURL2}},
+		config.RemoteConfig{Name: "team.fork", URLs: []string{"git@github.com:Team/mock.Repo+1.git"}})
+	if bare {
+		return path
+	}
+	return filepath.Join(path, DotGit)
+}
+
+func TestBackendConformance(t *testing.T) {
+	ctx := context.Background()
+	for name, backend := range backendsUnderTest(t) {
+		backend := backend
+		t.Run(name, func(t *testing.T) {
+			for _, bare := range []bool{false, true} {
+				gitDir := conformanceRepo(t, bare)
+
+				remotes, err := backend.Remotes(ctx, gitDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := []Remote{
+					{Name: "origin", URLs: []string{remoteURL1, remoteURL2}},
+					{Name: "team.fork", URLs: []string{"git@github.com:Team/mock.Repo+1.git"}},
+					{Name: "upstream", URLs: []string{remoteURL3}},
+				}
+				if !reflect.DeepEqual(remotes, expected) {
+					t.Errorf("bare=%v: Expected remotes %v, Got %v", bare, expected, remotes)
+				}
+
+				newURL := "https://gitlab.com/OldUsername/mockRepo.git"
+				if err := backend.ReplaceURL(ctx, gitDir, "origin", remoteURL1, newURL); err != nil {
+					t.Fatal(err)
+				}
+				// urls are matched exactly, not as patterns
+				if err := backend.ReplaceURL(ctx, gitDir, "team.fork", "git@github.com:Team/mockXRepo+1.git", newURL); err == nil {
+					t.Error("Expected ERROR when the current url only matches as a pattern")
+				}
+				if err := backend.ReplaceURL(ctx, gitDir, "team.fork", "git@github.com:Team/mock.Repo+1.git", "git@gitlab.com:Team/mock.Repo+1.git"); err != nil {
+					t.Fatal(err)
+				}
+				if err := backend.ReplaceURL(ctx, gitDir, "missing", remoteURL1, newURL); err == nil {
+					t.Error("Expected ERROR when replacing a url of a missing remote")
+				}
+				if err := backend.ReplaceURL(ctx, gitDir, "upstream", remoteURL1, newURL); err == nil {
+					t.Error("Expected ERROR when replacing a url the remote doesn't have")
+				}
+
+				remotes, err = backend.Remotes(ctx, gitDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected[0].URLs = []string{newURL, remoteURL2}
+				expected[1].URLs = []string{"git@gitlab.com:Team/mock.Repo+1.git"}
+				if !reflect.DeepEqual(remotes, expected) {
+					t.Errorf("bare=%v: Expected remotes %v after replacing, Got %v", bare, expected, remotes)
+				}
+
+				// the default fetch refspec must survive the rewrite
+				cfg, err := ReadGitConfigFile(filepath.Join(gitDir, "config"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fetch := cfg.Section("remote").Subsection("origin").Option("fetch"); fetch != "+refs/heads/*:refs/remotes/origin/*" {
+					t.Errorf("bare=%v: Expected fetch refspec to be kept, Got %q", bare, fetch)
+				}
+			}
+
+			empty := filepath.Join(t.TempDir(), "empty")
+			initRepo(t, empty, false)
+			if remotes, err := backend.Remotes(ctx, filepath.Join(empty, DotGit)); err != nil || len(remotes) != 0 {
+				t.Errorf("Expected no remotes, Got %v, %v", remotes, err)
+			}
+			if _, err := backend.Remotes(ctx, t.TempDir()); err == nil {
+				t.Error("Expected ERROR when reading a directory that isn't a repo")
+			}
+		})
+	}
+}
+
+func TestBackendAppliesPlan(t *testing.T) {
+	ctx := context.Background()
+	for name, backend := range backendsUnderTest(t) {
+		root := t.TempDir()
+		initRepo(t, filepath.Join(root, "api"), false, config.RemoteConfig{Name: "origin", URLs: []string{remoteURL1}})
+
+		repoMap, err := NewScanner(ScanOptions{Directories: []string{root}, Backend: backend}).Scan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set, err := testPlanner(PlanOptions{}).Plan(ctx, repoMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := Validate(ctx, backend, set); len(errs) != 0 {
+			t.Errorf("%s: Expected valid plan, Got %v", name, errs)
+		}
+		if err := NewApplier(ApplyOptions{Backend: backend}).Apply(ctx, set); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		remotes, err := backend.Remotes(ctx, filepath.Join(root, "api", DotGit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remotes[0].URLs[0] != "https://gitlab.com/OldUsername/mockRepo.git" {
+			t.Errorf("%s: Expected origin to be rewritten, Got %v", name, remotes)
+		}
+	}
+}
+
+func TestExecBackendIncludes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	backendsUnderTest(t)
+	path := t.TempDir()
+	initRepo(t, path, false)
+	included := filepath.Join(path, "remotes.inc")
+	if err := ioutil.WriteFile(included, []byte("[remote \"shared\"]\n\turl = "+remoteURL2+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "--git-dir", filepath.Join(path, DotGit), "config", "include.path", included)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	remotes, err := ExecBackend{}.Remotes(context.Background(), filepath.Join(path, DotGit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Remote{{Name: "shared", URLs: []string{remoteURL2}}}
+	if !reflect.DeepEqual(remotes, expected) {
+		t.Errorf("Expected remotes from the included file %v, Got %v", expected, remotes)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	backupManifest = "manifest.json"
+	backupRunsDir  = "runs"
+	// Run IDs sort in the order the runs were started
+	backupRunFormat = "20060102T150405Z"
+)
+
+// Returned by Restore when a repo's config has changed since grout wrote it
+var ErrConfigChanged = errors.New("config has changed since the run")
+
+// A BackupStore keeps a copy of every config grout changes, one directory
+// per run under Dir/runs
+type BackupStore struct {
+	Dir string
+}
+
+// The default backup directory, $XDG_STATE_HOME/grout or
+// ~/.local/state/grout
+func DefaultBackupDir() (string, error) {
+	if state := os.Getenv("XDG_STATE_HOME"); len(state) > 0 {
+		return filepath.Join(state, "grout"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "grout"), nil
+}
+
+// The configs backed up by a single apply
+type BackupRun struct {
+	ID      string        `json:"id"`
+	Started time.Time     `json:"started"`
+	Entries []BackupEntry `json:"entries"`
+
+	dir string
+	// Where the run's directory is created, for a run not yet written
+	base string
+}
+
+// A repo's config as it was before and after grout changed it
+type BackupEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// The copy of the original config, relative to the run directory
+	File string `json:"file"`
+	// sha256 of the config before and after the changes
+	OriginalHash string `json:"original_hash"`
+	AppliedHash  string `json:"applied_hash,omitempty"`
+}
+
+// Start a new run, named after the time it was started. The run's
+// directory isn't created until its first backup, so an apply that changes
+// nothing leaves no run behind
+func (s BackupStore) NewRun(started time.Time) (*BackupRun, error) {
+	started = started.UTC()
+	base := filepath.Join(s.Dir, backupRunsDir)
+	// still fail before anything is applied if backups can't be written
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return nil, fmt.Errorf("error creating backup directory: %w", err)
+	}
+	return &BackupRun{ID: started.Format(backupRunFormat), Started: started, base: base}, nil
+}
+
+// List every run, newest first
+func (s BackupStore) Runs() ([]*BackupRun, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.Dir, backupRunsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var runs []*BackupRun
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		run, err := s.Run(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs, nil
+}
+
+// Load a run by ID. "latest" loads the newest run
+func (s BackupStore) Run(id string) (*BackupRun, error) {
+	if id == "latest" {
+		runs, err := s.Runs()
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 {
+			return nil, fmt.Errorf("no backup runs found in %s", s.Dir)
+		}
+		return runs[0], nil
+	}
+	dir := filepath.Join(s.Dir, backupRunsDir, id)
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifest))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("backup run %s not found", id)
+	} else if err != nil {
+		return nil, err
+	}
+	run := &BackupRun{dir: dir}
+	if err := json.Unmarshal(data, run); err != nil {
+		return nil, fmt.Errorf("error reading backup run %s: %w", id, err)
+	}
+	return run, nil
+}
+
+// Copy a repo's config into the run before it is changed. A repo already
+// in the run keeps its first copy
+func (r *BackupRun) Backup(repo LocalRepository) error {
+	if r.entry(repo.Path) != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(repo.Path, "config"))
+	if err != nil {
+		return fmt.Errorf("error backing up config of %s: %w", repo.Path, err)
+	}
+	if err := r.create(); err != nil {
+		return err
+	}
+	file := fmt.Sprintf("%04d-%s.config", len(r.Entries)+1, repo.Name)
+	if err := ioutil.WriteFile(filepath.Join(r.dir, file), data, 0600); err != nil {
+		return fmt.Errorf("error backing up config of %s: %w", repo.Path, err)
+	}
+	r.Entries = append(r.Entries, BackupEntry{
+		Name:         repo.Name,
+		Path:         repo.Path,
+		File:         file,
+		OriginalHash: hashBytes(data),
+	})
+	return r.save()
+}
+
+// Record the config grout left a repo with, so a later restore can tell
+// whether anything else has changed it since
+func (r *BackupRun) Applied(repo LocalRepository) error {
+	entry := r.entry(repo.Path)
+	if entry == nil {
+		return nil
+	}
+	hash, err := hashFile(filepath.Join(repo.Path, "config"))
+	if err != nil {
+		return err
+	}
+	entry.AppliedHash = hash
+	return r.save()
+}
+
+// Write a backed up config back to its repo. Unless forced, this fails with
+// ErrConfigChanged if the config is no longer the one grout left behind
+func (r *BackupRun) Restore(entry BackupEntry, force bool) error {
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, entry.File))
+	if err != nil {
+		return fmt.Errorf("%s: error reading backup: %w", entry.Path, err)
+	}
+	lock, err := lockConfig(entry.Path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", entry.Path, err)
+	}
+	if !force {
+		current, err := hashFile(filepath.Join(entry.Path, "config"))
+		if err != nil {
+			lock.rollback()
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		if current != entry.AppliedHash && current != entry.OriginalHash {
+			lock.rollback()
+			return fmt.Errorf("%s: %w", entry.Path, ErrConfigChanged)
+		}
+	}
+	if err := lock.commit(data); err != nil {
+		return fmt.Errorf("%s: error restoring config: %w", entry.Path, err)
+	}
+	return nil
+}
+
+// Create the run's directory if it doesn't exist yet. Runs started in the
+// same second get a numbered suffix
+func (r *BackupRun) create() error {
+	if len(r.dir) > 0 {
+		return nil
+	}
+	id := r.Started.Format(backupRunFormat)
+	for n := 2; ; n++ {
+		err := os.Mkdir(filepath.Join(r.base, id), 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("error creating backup directory: %w", err)
+		}
+		id = fmt.Sprintf("%s-%d", r.Started.Format(backupRunFormat), n)
+	}
+	r.ID, r.dir = id, filepath.Join(r.base, id)
+	return nil
+}
+
+func (r *BackupRun) entry(path string) *BackupEntry {
+	for i := range r.Entries {
+		if r.Entries[i].Path == path {
+			return &r.Entries[i]
+		}
+	}
+	return nil
+}
+
+func (r *BackupRun) save() error {
+	data, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.dir, backupManifest), data, 0600)
+}
+
+func hashFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(data), nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
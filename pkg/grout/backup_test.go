@@ -0,0 +1,120 @@
+package grout
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+)
+
+func TestBackupRunIDs(t *testing.T) {
+	store := BackupStore{Dir: t.TempDir()}
+	started := time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+	first, err := store.NewRun(started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.NewRun(started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// runs are only written once they back something up
+	if runs, err := store.Runs(); err != nil || len(runs) != 0 {
+		t.Fatalf("Expected no runs before a backup, Got %v, %v", runs, err)
+	}
+	path := t.TempDir()
+	initRepo(t, path, false, config.RemoteConfig{Name: "origin", URLs: []string{remoteURL1}})
+	repo := LocalRepository{Name: "repo", Path: filepath.Join(path, DotGit)}
+	for _, run := range []*BackupRun{first, second} {
+		if err := run.Backup(repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.ID != "20210601T123000Z" || second.ID != "20210601T123000Z-2" {
+		t.Errorf("run IDs = %s, %s", first.ID, second.ID)
+	}
+
+	runs, err := store.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != second.ID {
+		t.Errorf("Runs() = %v, want newest first", runs)
+	}
+	latest, err := store.Run("latest")
+	if err != nil || latest.ID != second.ID {
+		t.Errorf("Run(latest) = %v, %v", latest, err)
+	}
+	if _, err := store.Run("19990101T000000Z"); err == nil {
+		t.Error("Expected ERROR for a missing run")
+	}
+}
+
+func TestApplyBackupAndRestore(t *testing.T) {
+	path := t.TempDir()
+	initRepo(t, path, false, config.RemoteConfig{Name: "origin", URLs: []string{remoteURL1}})
+	gitDir := filepath.Join(path, DotGit)
+	configPath := filepath.Join(gitDir, "config")
+	original, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := testPlanner(PlanOptions{}).Plan(context.Background(), RepoMap{Repos: []LocalRepository{{
+		Name:    "repo",
+		Path:    gitDir,
+		Remotes: []Remote{{Name: "origin", URLs: []string{remoteURL1}}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := BackupStore{Dir: t.TempDir()}
+	run, err := store.NewRun(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewApplier(ApplyOptions{Backups: run}).Apply(context.Background(), set); err != nil {
+		t.Fatal(err)
+	}
+	applied, _ := ioutil.ReadFile(configPath)
+	if string(applied) == string(original) {
+		t.Fatal("Expected the config to change")
+	}
+
+	// the manifest is read back from disk
+	run, err = store.Run(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Entries) != 1 || run.Entries[0].Path != gitDir || len(run.Entries[0].AppliedHash) == 0 {
+		t.Fatalf("run entries = %+v", run.Entries)
+	}
+
+	// a config changed since the run is only restored when forced
+	changed := append(applied, []byte("[gc]\n\tauto = 0\n")...)
+	if err := ioutil.WriteFile(configPath, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Restore(run.Entries[0], false); !errors.Is(err, ErrConfigChanged) {
+		t.Errorf("Restore() error = %v, want %v", err, ErrConfigChanged)
+	}
+	if got, _ := ioutil.ReadFile(configPath); string(got) != string(changed) {
+		t.Error("Expected a refused restore to leave the config alone")
+	}
+	if err := run.Restore(run.Entries[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(configPath); string(got) != string(original) {
+		t.Errorf("restored config =\n%s\nwant\n%s", got, original)
+	}
+
+	// restoring again is a no-op rather than a conflict
+	if err := run.Restore(run.Entries[0], false); err != nil {
+		t.Errorf("second Restore() error = %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"sort"
+	"strings"
+)
+
+// The port each scheme uses when a url doesn't give one
+var defaultPorts = map[string]string{
+	"ssh":   "22",
+	"https": "443",
+	"http":  "80",
+	"git":   "9418",
+}
+
+// Several remotes of one repo that point at the same canonical repo
+type DuplicateRemotes struct {
+	Repo      string   `json:"repo"`
+	Path      string   `json:"path"`
+	Canonical string   `json:"canonical"`
+	Remotes   []string `json:"remotes"`
+	URLs      []string `json:"urls"`
+}
+
+// Reduce a remote url to host/org/repo, so that urls for the same repo
+// compare equal whatever protocol, user, default port, case, .git suffix
+// or trailing slash they were written with. Hosts, orgs and repo names are
+// compared without regard to case, as the common git hosts do. Returns
+// false for local paths and urls without a path on the host
+func CanonicalURL(url string) (string, bool) {
+	host, path, ok := splitHostPath(url)
+	if !ok {
+		return "", false
+	}
+	path = strings.Trim(path, "/")
+	path = strings.TrimSuffix(path, DotGit)
+	path = strings.Trim(path, "/")
+	if len(host) == 0 || len(path) == 0 {
+		return "", false
+	}
+	return strings.ToLower(host + "/" + path), true
+}
+
+// Split a url with a scheme, or an scp-like url, into its host and the
+// path on the host. The host keeps its port unless it is the scheme's
+// default. Users are left out
+func splitHostPath(url string) (string, string, bool) {
+	if i := strings.Index(url, "://"); i > 0 {
+		scheme := URLProtocol(url)
+		if scheme == ProtocolFile {
+			return "", "", false
+		}
+		rest := url[i+3:]
+		slash := strings.Index(rest, "/")
+		if slash < 0 {
+			return "", "", false
+		}
+		authority, path := rest[:slash], rest[slash:]
+		if at := strings.LastIndex(authority, "@"); at >= 0 {
+			authority = authority[at+1:]
+		}
+		if colon := strings.LastIndex(authority, ":"); colon >= 0 && !strings.Contains(authority[colon:], "]") {
+			if port := authority[colon+1:]; len(port) == 0 || port == defaultPorts[scheme] {
+				authority = authority[:colon]
+			}
+		}
+		return authority, path, true
+	}
+	if URLProtocol(url) != ProtocolSSH {
+		return "", "", false
+	}
+	colon := strings.Index(url, ":")
+	host := url[:colon]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	return host, url[colon+1:], true
+}
+
+// Find the repos with more than one remote pointing at the same canonical
+// repo, such as an origin and an upstream left over from an old host
+func FindDuplicateRemotes(repoMap RepoMap) []DuplicateRemotes {
+	var duplicates []DuplicateRemotes
+	for _, repo := range repoMap.Repos {
+		byCanonical := map[string]*DuplicateRemotes{}
+		var order []string
+		for _, remote := range repo.Remotes {
+			for _, url := range remote.URLs {
+				canonical, ok := CanonicalURL(url)
+				if !ok {
+					continue
+				}
+				dup, found := byCanonical[canonical]
+				if !found {
+					dup = &DuplicateRemotes{Repo: repo.Name, Path: repo.Path, Canonical: canonical}
+					byCanonical[canonical] = dup
+					order = append(order, canonical)
+				}
+				if !containsString(dup.Remotes, remote.Name) {
+					dup.Remotes = append(dup.Remotes, remote.Name)
+				}
+				dup.URLs = append(dup.URLs, RedactURL(url))
+			}
+		}
+		for _, canonical := range order {
+			if dup := byCanonical[canonical]; len(dup.Remotes) > 1 {
+				duplicates = append(duplicates, *dup)
+			}
+		}
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool { return duplicates[i].Path < duplicates[j].Path })
+	return duplicates
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errRemoteNotFound = errors.New("remote not found")
+	errURLNotFound    = errors.New("url not found")
+)
+
+// Replace the value of a single url line of a remote in the text of a git
+// config file. Only the value is rewritten; comments, whitespace, quoting,
+// line endings and every other line are kept exactly as they were
+func replaceConfigURL(data []byte, remote, currentURL, newURL string) ([]byte, error) {
+	text := string(data)
+	foundRemote := false
+	section, subsection := "", ""
+
+	for i := 0; i < len(text); {
+		i = skipBlanks(text, i)
+		if i >= len(text) {
+			break
+		}
+		switch c := text[i]; {
+		case c == '\n':
+			i++
+			continue
+		case c == '#' || c == ';':
+			i = lineEnd(text, i)
+			continue
+		case c == '[':
+			end, name, sub, ok := parseSectionHeader(text, i)
+			if !ok {
+				i = lineEnd(text, i)
+				continue
+			}
+			section, subsection = name, sub
+			if isRemoteSection(section, subsection, remote) {
+				foundRemote = true
+			}
+			// a variable may follow the header on the same line
+			i = end
+			continue
+		}
+
+		key, valueStart, valueEnd, value, next := parseVariable(text, i)
+		if isRemoteSection(section, subsection, remote) && strings.EqualFold(key, "url") && value == currentURL {
+			raw := text[valueStart:valueEnd]
+			quoted := len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"'
+			edited := text[:valueStart] + encodeConfigValue(newURL, quoted) + text[valueEnd:]
+			return []byte(edited), nil
+		}
+		i = next
+	}
+
+	if !foundRemote {
+		return nil, errRemoteNotFound
+	}
+	return nil, errURLNotFound
+}
+
+// Reports whether a section header names the given remote. The deprecated
+// [remote.name] form is case insensitive, as git lower cases it
+func isRemoteSection(section, subsection, remote string) bool {
+	if strings.EqualFold(section, "remote") && subsection == remote {
+		return true
+	}
+	return strings.EqualFold(section, "remote."+remote)
+}
+
+func skipBlanks(text string, i int) int {
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t' || text[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// The index just past the end of the line holding i
+func lineEnd(text string, i int) int {
+	if end := strings.IndexByte(text[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(text)
+}
+
+// Parse a [section "subsection"] or [section] header starting at i. Returns
+// the index after the closing bracket
+func parseSectionHeader(text string, i int) (int, string, string, bool) {
+	i++
+	start := i
+	for i < len(text) && (isKeyChar(text[i]) || text[i] == '.') {
+		i++
+	}
+	name := text[start:i]
+	i = skipBlanks(text, i)
+	if i < len(text) && text[i] == ']' {
+		return i + 1, name, "", len(name) > 0
+	}
+	if i >= len(text) || text[i] != '"' {
+		return i, "", "", false
+	}
+
+	var sub strings.Builder
+	for i++; i < len(text) && text[i] != '"'; i++ {
+		if text[i] == '\n' {
+			return i, "", "", false
+		}
+		if text[i] == '\\' && i+1 < len(text) {
+			i++
+		}
+		sub.WriteByte(text[i])
+	}
+	if i+1 >= len(text) || text[i+1] != ']' {
+		return i, "", "", false
+	}
+	return i + 2, name, sub.String(), len(name) > 0
+}
+
+func isKeyChar(c byte) bool {
+	return c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Parse a variable starting at i. Returns its key, the span of its raw
+// value (without surrounding whitespace or a trailing comment), the decoded
+// value and the index of the next line. Values may continue over several
+// lines with a trailing backslash
+func parseVariable(text string, i int) (string, int, int, string, int) {
+	start := i
+	for i < len(text) && isKeyChar(text[i]) {
+		i++
+	}
+	key := text[start:i]
+	i = skipBlanks(text, i)
+	if i >= len(text) || text[i] != '=' {
+		return key, i, i, "", lineEnd(text, i)
+	}
+	i = skipBlanks(text, i+1)
+
+	valueStart, valueEnd := i, i
+	var value strings.Builder
+	// length of value up to its last character that isn't unquoted space
+	significant := 0
+	inQuote := false
+	for i < len(text) {
+		c := text[i]
+		if c == '\n' {
+			break
+		}
+		if !inQuote && (c == '#' || c == ';') {
+			break
+		}
+		switch {
+		case c == '\\' && i+1 < len(text):
+			escaped := text[i+1]
+			if escaped == '\n' || (escaped == '\r' && i+2 < len(text) && text[i+2] == '\n') {
+				// continuation onto the next line
+				i = lineEnd(text, i)
+				continue
+			}
+			switch escaped {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			default:
+				value.WriteByte(escaped)
+			}
+			i += 2
+			significant, valueEnd = value.Len(), i
+			continue
+		case c == '"':
+			inQuote = !inQuote
+			i++
+			valueEnd = i
+			continue
+		}
+		value.WriteByte(c)
+		i++
+		if inQuote || (c != ' ' && c != '\t' && c != '\r') {
+			significant, valueEnd = value.Len(), i
+		}
+	}
+	return key, valueStart, valueEnd, value.String()[:significant], lineEnd(text, i)
+}
+
+// Encode a value for a config file, quoting it if it was quoted before or
+// if it would otherwise be read back differently
+func encodeConfigValue(value string, quoted bool) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	encoded := replacer.Replace(value)
+	if !quoted {
+		quoted = strings.ContainsAny(value, "#;") || strings.TrimSpace(value) != value
+	}
+	if quoted {
+		return `"` + encoded + `"`
+	}
+	return encoded
+}
@@ -0,0 +1,137 @@
+package grout
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Each case rewrites one url of a real-world config. The result must match
+// testdata/configedit/<name>.golden byte for byte
+var configEditCases = []struct {
+	name       string
+	remote     string
+	currentURL string
+	newURL     string
+}{
+	{"clone", "origin", "git@github.com:acme/widgets.git", "git@github.com:acme-platform/widgets.git"},
+	{"multi-url", "origin", "git@gitlab.com:acme/widgets.git", "git@gitlab.com:acme-platform/widgets.git"},
+	{"includes", "upstream", "https://github.com/acme/widgets.git", "https://github.com/acme-platform/widgets.git"},
+	{"quoted", "origin", "git@github.com:acme/widgets.git", "git@github.com:acme-platform/widgets.git"},
+	{"crlf", "origin", "https://dev.azure.com/acme/widgets/_git/widgets", "https://github.com/acme-platform/widgets.git"},
+	{"legacy", "fork", "git@github.com:me/widgets.git", "git@github.com:me/gadgets.git"},
+	{"continuation", "origin", "https://git.example.com/acme/widgets.git", "https://git.example.com/acme-platform/widgets.git"},
+}
+
+func TestReplaceConfigURLGolden(t *testing.T) {
+	for _, tc := range configEditCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input, err := ioutil.ReadFile(filepath.Join("testdata", "configedit", tc.name+".config"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := replaceConfigURL(input, tc.remote, tc.currentURL, tc.newURL)
+			if err != nil {
+				t.Fatalf("replaceConfigURL() error = %v", err)
+			}
+
+			goldenPath := filepath.Join("testdata", "configedit", tc.name+".golden")
+			if *updateGolden {
+				if err := ioutil.WriteFile(goldenPath, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("replaceConfigURL() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+// git must read back the new url from every golden file
+func TestReplaceConfigURLGoldenReadByGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for _, tc := range configEditCases {
+		t.Run(tc.name, func(t *testing.T) {
+			goldenPath := filepath.Join("testdata", "configedit", tc.name+".golden")
+			out, err := exec.Command("git", "config", "--file", goldenPath, "--get-all", "remote."+tc.remote+".url").Output()
+			if err != nil {
+				t.Fatalf("git config error = %v", err)
+			}
+			urls := strings.Split(strings.TrimSpace(string(out)), "\n")
+			if !containsString(urls, tc.newURL) || containsString(urls, tc.currentURL) {
+				t.Errorf("git read urls %v, want %s in place of %s", urls, tc.newURL, tc.currentURL)
+			}
+		})
+	}
+}
+
+func TestReplaceConfigURLMissing(t *testing.T) {
+	input := []byte("[remote \"origin\"]\n\turl = git@github.com:acme/widgets.git\n# url = git@github.com:acme/gadgets.git\n")
+	if _, err := replaceConfigURL(input, "upstream", "git@github.com:acme/widgets.git", "x"); err != errRemoteNotFound {
+		t.Errorf("missing remote error = %v, want %v", err, errRemoteNotFound)
+	}
+	if _, err := replaceConfigURL(input, "origin", "git@github.com:acme/gadgets.git", "x"); err != errURLNotFound {
+		t.Errorf("commented url error = %v, want %v", err, errURLNotFound)
+	}
+}
+
+func TestEncodeConfigValue(t *testing.T) {
+	tests := []struct {
+		value  string
+		quoted bool
+		want   string
+	}{
+		{"git@github.com:acme/widgets.git", false, "git@github.com:acme/widgets.git"},
+		{"git@github.com:acme/widgets.git", true, `"git@github.com:acme/widgets.git"`},
+		{"https://example.com/repo#frag", false, `"https://example.com/repo#frag"`},
+		{`C:\repos\widgets`, false, `C:\\repos\\widgets`},
+	}
+	for _, tt := range tests {
+		if got := encodeConfigValue(tt.value, tt.quoted); got != tt.want {
+			t.Errorf("encodeConfigValue(%q, %v) = %s, want %s", tt.value, tt.quoted, got, tt.want)
+		}
+	}
+}
+
+// Applying with go-git leaves everything but the url line untouched
+func TestGoGitBackendKeepsConfigLayout(t *testing.T) {
+	input, err := ioutil.ReadFile(filepath.Join("testdata", "configedit", "includes.config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := filepath.Join(t.TempDir(), DotGit)
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(gitDir, "config"), input, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = GoGitBackend{}.ReplaceURL(context.Background(), gitDir, "upstream",
+		"https://github.com/acme/widgets.git", "https://github.com/acme-platform/widgets.git")
+	if err != nil {
+		t.Fatalf("ReplaceURL() error = %v", err)
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(gitDir, "config"))
+	want, _ := ioutil.ReadFile(filepath.Join("testdata", "configedit", "includes.golden"))
+	if string(got) != string(want) {
+		t.Errorf("config after ReplaceURL =\n%s\nwant\n%s", got, want)
+	}
+	if info, _ := os.Stat(filepath.Join(gitDir, "config")); info.Mode().Perm() != 0600 {
+		t.Errorf("config mode = %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// How a Fetcher refreshes the remotes changed by a ChangeSet
+type FetchOptions struct {
+	// Called after each remote is fetched
+	Progress func(result FetchResult)
+	// Fetches each remote. Defaults to go-git
+	Backend Backend
+}
+
+// A Fetcher fetches each changed remote from its new url, so its
+// remote-tracking branches and HEAD describe the new host
+type Fetcher struct {
+	opts FetchOptions
+}
+
+// The result of fetching one changed remote
+type FetchResult struct {
+	Repo   LocalRepository `json:"repo"`
+	Remote string          `json:"remote"`
+	// The branch refs/remotes/<remote>/HEAD now points at, if the new host
+	// advertises one. A HEAD left pointing at a pruned branch is removed
+	Head string `json:"head,omitempty"`
+	// Local branches whose upstream branch doesn't exist on the new host
+	MissingUpstreams []string `json:"missing_upstreams,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+func NewFetcher(opts FetchOptions) *Fetcher {
+	opts.Backend = backendOrDefault(opts.Backend)
+	return &Fetcher{opts: opts}
+}
+
+// Fetch every changed remote in the ChangeSet, leaving out excluded repos
+// and changes. The returned error is only set if the context is done
+func (f *Fetcher) Fetch(ctx context.Context, set ChangeSet) ([]FetchResult, error) {
+	var results []FetchResult
+	for _, plan := range set.Plans {
+		if plan.Excluded {
+			continue
+		}
+		planResults, err := f.FetchPlan(ctx, plan)
+		results = append(results, planResults...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Fetch each remote changed by a single repo's plan once
+func (f *Fetcher) FetchPlan(ctx context.Context, plan RepoPlan) ([]FetchResult, error) {
+	var results []FetchResult
+	var fetched []string
+	for _, change := range plan.Changes {
+		if change.Excluded || containsString(fetched, change.Name) {
+			continue
+		}
+		fetched = append(fetched, change.Name)
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		result := FetchResult{Repo: plan.Repo, Remote: change.Name}
+		head, err := f.opts.Backend.Fetch(ctx, plan.Repo.Path, change.Name)
+		if err == nil {
+			result.Head = head
+			result.MissingUpstreams, err = f.missingUpstreams(ctx, plan.Repo.Path, change.Name)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return results, ctxErr
+		}
+		if err != nil {
+			result.Error = RedactURL(err.Error())
+		}
+		if f.opts.Progress != nil {
+			f.opts.Progress(result)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Local branches set to track a branch of the remote that it no longer has
+func (f *Fetcher) missingUpstreams(ctx context.Context, gitDir, remote string) ([]string, error) {
+	cfg, err := ReadGitConfigFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading config of %s: %w", gitDir, err)
+	}
+	tracking, err := f.opts.Backend.TrackingRefs(ctx, gitDir, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, branch := range cfg.Section("branch").Subsections {
+		merge := branch.Option("merge")
+		if branch.Option("remote") != remote || !strings.HasPrefix(merge, "refs/heads/") {
+			continue
+		}
+		if _, ok := tracking[strings.TrimPrefix(merge, "refs/heads/")]; !ok {
+			missing = append(missing, branch.Name)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// Fetch with go-git, then prune the tracking branches the remote no longer
+// advertises and point the remote's HEAD at its default branch, as
+// git fetch --prune and git remote set-head --auto would
+func (GoGitBackend) Fetch(ctx context.Context, gitDir, remote string) (string, error) {
+	r, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return "", fmt.Errorf("error opening repo %s: %w", gitDir, err)
+	}
+	rem, err := r.Remote(remote)
+	if err != nil {
+		return "", fmt.Errorf("remote %s does not exist", remote)
+	}
+	err = rem.FetchContext(ctx, &git.FetchOptions{RemoteName: remote})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return "", fmt.Errorf("error fetching %s: %w", remote, err)
+	}
+	advertised, err := rem.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error listing %s: %w", remote, err)
+	}
+
+	heads := map[string]bool{}
+	head := ""
+	for _, ref := range advertised {
+		if ref.Name().IsBranch() {
+			heads[ref.Name().Short()] = true
+		}
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			head = ref.Target().Short()
+		}
+	}
+
+	prefix := "refs/remotes/" + remote + "/"
+	iter, err := r.References()
+	if err != nil {
+		return "", fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+	var stale []plumbing.ReferenceName
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if strings.HasPrefix(name, prefix) && name != prefix+"HEAD" && !heads[strings.TrimPrefix(name, prefix)] {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+	for _, name := range stale {
+		if err := r.Storer.RemoveReference(name); err != nil {
+			return "", fmt.Errorf("error pruning %s: %w", name, err)
+		}
+	}
+
+	if len(head) == 0 || !heads[head] {
+		// a HEAD left pointing at a pruned branch would break every
+		// command that resolves <remote>/HEAD
+		current, err := r.Storer.Reference(plumbing.ReferenceName(prefix + "HEAD"))
+		if err == nil && current.Type() == plumbing.SymbolicReference &&
+			!heads[strings.TrimPrefix(current.Target().String(), prefix)] {
+			if err := r.Storer.RemoveReference(current.Name()); err != nil {
+				return "", fmt.Errorf("error removing %sHEAD: %w", prefix, err)
+			}
+		}
+		return "", nil
+	}
+	headRef := plumbing.NewSymbolicReference(plumbing.ReferenceName(prefix+"HEAD"), plumbing.ReferenceName(prefix+head))
+	if err := r.Storer.SetReference(headRef); err != nil {
+		return "", fmt.Errorf("error setting %sHEAD: %w", prefix, err)
+	}
+	return head, nil
+}
+
+func (b ExecBackend) Fetch(ctx context.Context, gitDir, remote string) (string, error) {
+	if _, err := b.git(ctx, gitDir, "fetch", "--quiet", "--prune", remote); err != nil {
+		return "", err
+	}
+	// set-head fails when the remote doesn't advertise a HEAD, which
+	// leaves the current one as it is
+	headName := "refs/remotes/" + remote + "/HEAD"
+	_, setErr := b.git(ctx, gitDir, "remote", "set-head", remote, "--auto")
+	out, err := b.git(ctx, gitDir, "symbolic-ref", "--quiet", headName)
+	if err != nil {
+		return "", nil
+	}
+	target := strings.TrimSpace(out)
+	if _, err := b.git(ctx, gitDir, "show-ref", "--verify", "--quiet", target); err != nil {
+		// HEAD points at a branch the prune removed
+		if _, err := b.git(ctx, gitDir, "symbolic-ref", "--delete", headName); err != nil {
+			return "", fmt.Errorf("error removing %s: %w", headName, err)
+		}
+		return "", nil
+	}
+	if setErr != nil {
+		return "", nil
+	}
+	return strings.TrimPrefix(target, "refs/remotes/"+remote+"/"), nil
+}
@@ -0,0 +1,106 @@
+package grout
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+)
+
+func TestFetch(t *testing.T) {
+	for name, backend := range backendsUnderTest(t) {
+		t.Run(name, func(t *testing.T) {
+			root, gitDir := verifyFixture(t)
+			work := filepath.Dir(gitDir)
+			runGit(t, work, "checkout", "-q", "dev")
+
+			// the new host has no dev branch and defaults to trunk
+			newURL := filepath.Join(root, "partial.git")
+			runGit(t, filepath.Join(root, "src"), "push", "-q", newURL, "main:trunk")
+			runGit(t, newURL, "symbolic-ref", "HEAD", "refs/heads/trunk")
+			runGit(t, work, "remote", "set-url", "origin", newURL)
+
+			set := ChangeSet{Plans: []RepoPlan{{
+				Repo: LocalRepository{Name: "work", Path: gitDir},
+				Changes: []RemoteChange{
+					{Name: "origin", CurrentURLs: []string{filepath.Join(root, "old.git")}, NewURLs: []string{newURL}},
+					{Name: "origin", CurrentURLs: []string{"unused"}, NewURLs: []string{"unused"}, Excluded: true},
+				},
+			}}}
+			results, err := NewFetcher(FetchOptions{Backend: backend}).Fetch(context.Background(), set)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("Fetch() returned %d results, want 1", len(results))
+			}
+			result := results[0]
+			if len(result.Error) > 0 {
+				t.Fatalf("Fetch() error = %s", result.Error)
+			}
+			if result.Head != "trunk" {
+				t.Errorf("Head = %q, want trunk", result.Head)
+			}
+			if !reflect.DeepEqual(result.MissingUpstreams, []string{"dev"}) {
+				t.Errorf("MissingUpstreams = %v, want [dev]", result.MissingUpstreams)
+			}
+
+			tracking, err := backend.TrackingRefs(context.Background(), gitDir, "origin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := tracking["dev"]; ok {
+				t.Error("Expected origin/dev to be pruned")
+			}
+			if _, ok := tracking["trunk"]; !ok {
+				t.Errorf("Expected origin/trunk to be fetched, got %v", tracking)
+			}
+		})
+	}
+}
+
+func TestFetchRemovesStaleHead(t *testing.T) {
+	for name, backend := range backendsUnderTest(t) {
+		t.Run(name, func(t *testing.T) {
+			root, gitDir := verifyFixture(t)
+			work := filepath.Dir(gitDir)
+
+			// the new host only has dev, and advertises no HEAD
+			newURL := filepath.Join(root, "headless.git")
+			runGit(t, root, "init", "-q", "--bare", newURL)
+			runGit(t, filepath.Join(root, "src"), "push", "-q", newURL, "dev")
+			runGit(t, newURL, "symbolic-ref", "HEAD", "refs/heads/missing")
+			runGit(t, work, "remote", "set-url", "origin", newURL)
+
+			head, err := backend.Fetch(context.Background(), gitDir, "origin")
+			if err != nil || len(head) > 0 {
+				t.Fatalf("Fetch() = %q, %v, want no head", head, err)
+			}
+			r, err := git.PlainOpen(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref, err := r.Storer.Reference("refs/remotes/origin/HEAD"); err == nil {
+				t.Errorf("Expected origin/HEAD to be removed, still points at %s", ref.Target())
+			}
+		})
+	}
+}
+
+func TestFetchReportsErrors(t *testing.T) {
+	_, gitDir := verifyFixture(t)
+	set := ChangeSet{Plans: []RepoPlan{{
+		Repo:    LocalRepository{Name: "work", Path: gitDir},
+		Changes: []RemoteChange{{Name: "upstream", NewURLs: []string{"file:///nowhere.git"}}},
+	}}}
+	var progress []FetchResult
+	results, err := NewFetcher(FetchOptions{Progress: func(r FetchResult) { progress = append(progress, r) }}).Fetch(context.Background(), set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Error) == 0 || len(progress) != 1 {
+		t.Errorf("Fetch() = %+v, want an error for the missing remote", results)
+	}
+}
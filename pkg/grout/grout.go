@@ -0,0 +1,162 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package grout finds git repos, plans changes to their remotes and applies
+// those plans. A Scanner maps the repos under a set of directories, a
+// Planner turns that map into a ChangeSet and an Applier writes a ChangeSet
+// to each repo's config. None of them share state, so several plans can be
+// made and applied in one process.
+package grout
+
+import (
+	"strings"
+)
+
+const (
+	DotGit = ".git"
+
+	// Section of git config holding grout settings, such as grout.skip
+	ConfigSection = "grout"
+
+	// Marker file that opts a repo out of migration
+	IgnoreFile = ".grout-ignore"
+
+	// Review decisions recorded on a RepoPlan
+	DecisionAccepted = "accepted"
+	DecisionSkipped  = "skipped"
+	DecisionEdited   = "edited"
+
+	justGit   = "git"
+	http      = "http"
+	twoSpaces = "  "
+)
+
+type SplitUrl struct {
+	Type    string
+	BaseURL string
+	Org     string
+	Repo    string
+}
+
+type Remote struct {
+	Name string   `json:"name"`
+	URLs []string `json:"urls"`
+}
+
+// Path is the repo's .git directory as it was found, made absolute with ~
+// and $VARS expanded; it is the path grout reads and writes. GivenPath is
+// the same directory under the search directory or listed path as the user
+// typed it, when that differs. CanonicalPath has every symlink resolved
+type LocalRepository struct {
+	Name          string   `json:"name"`
+	Path          string   `json:"path"`
+	GivenPath     string   `json:"given_path,omitempty"`
+	CanonicalPath string   `json:"canonical_path,omitempty"`
+	RemoteSlug    string   `json:"remote_slug,omitempty"`
+	Remotes       []Remote `json:"remotes"`
+}
+
+// A repo that was found but left out of planning, and why
+type SkippedRepository struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Errors holds problems with individual paths or repos that didn't stop
+// the scan
+type RepoMap struct {
+	Meta    map[string]string   `json:"meta"`
+	Repos   []LocalRepository   `json:"repos"`
+	Skipped []SkippedRepository `json:"skipped"`
+	Errors  []error             `json:"-"`
+}
+
+// Change structs represent changes to a repo's remotes
+type RemoteChange struct {
+	Name         string   `json:"name"`
+	Organization string   `json:"newOrganization"`
+	CurrentURLs  []string `json:"current_urls"`
+	NewURLs      []string `json:"new_urls"`
+	Excluded     bool     `json:"excluded,omitempty"`
+	// Set when the current url uses a host alias from the ssh config
+	SSHAlias *SSHAlias `json:"ssh_alias,omitempty"`
+}
+
+type RepoPlan struct {
+	Repo       LocalRepository `json:"repo"`
+	Changes    []RemoteChange  `json:"changes"`
+	HasChanges bool            `json:"has_changes"`
+	Excluded   bool            `json:"excluded,omitempty"`
+	Decision   string          `json:"decision,omitempty"`
+	// Set when the plan was made if the repo has work that hasn't been
+	// pushed to the remotes being changed
+	UnpushedWork *UnpushedWork `json:"unpushed_work,omitempty"`
+}
+
+// A remote url that can't work as it is, such as a git:// url on a host
+// that no longer serves the git protocol
+type BrokenRemote struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Remote string `json:"remote"`
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+type ChangeSet struct {
+	Count   int                 `json:"count"`
+	Plans   []RepoPlan          `json:"plans"`
+	Skipped []SkippedRepository `json:"skipped,omitempty"`
+	// Remote urls found while planning that are broken, whether or not
+	// the plan changes them
+	Broken []BrokenRemote `json:"broken,omitempty"`
+	// Problems checking single repos while planning
+	Errors []error `json:"-"`
+}
+
+func UrlSplit(r rune) bool {
+	return r == ':' || r == '/' || r == '@'
+}
+
+// Split a remote url into its type, host, org and repo. The user and
+// password of an http(s) url are left out. Returns false for urls grout
+// can't parse
+func ParseURL(url string) (SplitUrl, bool) {
+	if userinfo, ok := urlUserinfo(url); ok && strings.HasPrefix(url, http) {
+		url = strings.Replace(url, userinfo+"@", "", 1)
+	}
+	fields := strings.FieldsFunc(url, UrlSplit)
+	if len(fields) != 4 {
+		return SplitUrl{}, false
+	}
+	return SplitUrl{
+		Type:    fields[0],
+		BaseURL: fields[1],
+		Org:     fields[2],
+		Repo:    fields[3],
+	}, true
+}
+
+// Reports whether a url can be used as a remote: one ParseURL splits, or
+// an ssh url without a user or with a port, as ssh alias urls may be
+func ValidRemoteURL(url string) bool {
+	if _, ok := ParseURL(url); ok {
+		return true
+	}
+	_, ok := CanonicalURL(url)
+	return ok && URLProtocol(url) == ProtocolSSH
+}
@@ -0,0 +1,202 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"sort"
+	"strings"
+)
+
+// Protocols a remote url may use
+const (
+	ProtocolSSH   = "ssh"
+	ProtocolHTTPS = "https"
+	ProtocolHTTP  = "http"
+	ProtocolGit   = "git"
+	ProtocolFile  = "file"
+)
+
+// Every remote url of every repo found, with counts by host and org and
+// the repos whose remotes point at the same repo more than once
+type Inventory struct {
+	Hosts      []HostCount        `json:"hosts"`
+	Entries    []InventoryEntry   `json:"entries"`
+	Duplicates []DuplicateRemotes `json:"duplicates,omitempty"`
+}
+
+// A single url of a repo's remote, with any password or token redacted.
+// Host, Org and RepoName are empty for urls grout can't parse
+type InventoryEntry struct {
+	Repo     string `json:"repo"`
+	Path     string `json:"path"`
+	Remote   string `json:"remote"`
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Org      string `json:"org"`
+	RepoName string `json:"repo_name"`
+	// The url as CanonicalURL reduces it, if it can
+	Canonical string `json:"canonical,omitempty"`
+
+	rawURL string
+}
+
+// How many remote urls, and how many distinct repos, point at a host
+type HostCount struct {
+	Host    string     `json:"host"`
+	Remotes int        `json:"remotes"`
+	Repos   int        `json:"repos"`
+	Orgs    []OrgCount `json:"orgs"`
+}
+
+// How many remote urls, and how many distinct repos, point at an org
+type OrgCount struct {
+	Org     string `json:"org"`
+	Remotes int    `json:"remotes"`
+	Repos   int    `json:"repos"`
+}
+
+// List every remote url in a RepoMap, sorted by host, org, repo and remote.
+// Hosts and orgs are lowercased so they are counted together
+func BuildInventory(repoMap RepoMap) Inventory {
+	var inventory Inventory
+	for _, repo := range repoMap.Repos {
+		for _, remote := range repo.Remotes {
+			for _, url := range remote.URLs {
+				entry := InventoryEntry{
+					Repo:     repo.Name,
+					Path:     repo.Path,
+					Remote:   remote.Name,
+					URL:      RedactURL(url),
+					Protocol: URLProtocol(url),
+					rawURL:   url,
+				}
+				if split, ok := splitRemoteURL(url); ok {
+					entry.Host = strings.ToLower(split.BaseURL)
+					entry.Org = strings.ToLower(split.Org)
+					entry.RepoName = strings.TrimSuffix(split.Repo, DotGit)
+				}
+				entry.Canonical, _ = CanonicalURL(url)
+				inventory.Entries = append(inventory.Entries, entry)
+			}
+		}
+	}
+	sort.SliceStable(inventory.Entries, func(i, j int) bool {
+		a, b := inventory.Entries[i], inventory.Entries[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Org != b.Org {
+			return a.Org < b.Org
+		}
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		return a.Remote < b.Remote
+	})
+	inventory.Hosts = countInventory(inventory.Entries)
+	inventory.Duplicates = FindDuplicateRemotes(repoMap)
+	return inventory
+}
+
+// The part of the inventory covering the duplicate remotes, recounted
+func (inventory Inventory) OnlyDuplicates() Inventory {
+	duplicated := map[string]bool{}
+	for _, dup := range inventory.Duplicates {
+		duplicated[dup.Path+"\x00"+dup.Canonical] = true
+	}
+	only := Inventory{Duplicates: inventory.Duplicates}
+	for _, entry := range inventory.Entries {
+		if duplicated[entry.Path+"\x00"+entry.Canonical] {
+			only.Entries = append(only.Entries, entry)
+		}
+	}
+	only.Hosts = countInventory(only.Entries)
+	return only
+}
+
+// Count the entries by host and org. Entries are already sorted, so hosts
+// and orgs come out sorted too
+func countInventory(entries []InventoryEntry) []HostCount {
+	var hosts []HostCount
+	hostRepos := map[string]map[string]bool{}
+	orgRepos := map[string]map[string]bool{}
+	for _, entry := range entries {
+		if len(hosts) == 0 || hosts[len(hosts)-1].Host != entry.Host {
+			hosts = append(hosts, HostCount{Host: entry.Host})
+			hostRepos[entry.Host] = map[string]bool{}
+		}
+		host := &hosts[len(hosts)-1]
+		if len(host.Orgs) == 0 || host.Orgs[len(host.Orgs)-1].Org != entry.Org {
+			host.Orgs = append(host.Orgs, OrgCount{Org: entry.Org})
+			orgRepos[entry.Host+"/"+entry.Org] = map[string]bool{}
+		}
+		org := &host.Orgs[len(host.Orgs)-1]
+
+		host.Remotes++
+		org.Remotes++
+		hostRepos[entry.Host][entry.Path] = true
+		orgRepos[entry.Host+"/"+entry.Org][entry.Path] = true
+		host.Repos = len(hostRepos[entry.Host])
+		org.Repos = len(orgRepos[entry.Host+"/"+entry.Org])
+	}
+	return hosts
+}
+
+// The protocol a remote url uses. scp-like urls (user@host:path) are ssh
+// and plain paths are file urls
+func URLProtocol(url string) string {
+	if i := strings.Index(url, "://"); i > 0 {
+		scheme := strings.ToLower(url[:i])
+		if scheme == "git+ssh" || scheme == "ssh+git" {
+			return ProtocolSSH
+		}
+		return scheme
+	}
+	// a single letter before the colon is a windows drive
+	if colon := strings.Index(url, ":"); colon > 1 && !strings.ContainsAny(url[:colon], `/\`) {
+		return ProtocolSSH
+	}
+	return ProtocolFile
+}
+
+// Split a remote url as ParseURL does, leaving out any user info in a url
+// with a scheme, so ssh://git@host/org/repo and https://user@host/org/repo
+// split too
+func splitRemoteURL(url string) (SplitUrl, bool) {
+	if userinfo, ok := urlUserinfo(url); ok {
+		url = strings.Replace(url, userinfo+"@", "", 1)
+	}
+	return ParseURL(url)
+}
+
+// The user info (user or user:password) in a url with a scheme. scp-like
+// urls are left alone, as their user is only ever an ssh login
+func urlUserinfo(url string) (string, bool) {
+	scheme := strings.Index(url, "://")
+	if scheme <= 0 {
+		return "", false
+	}
+	authority := url[scheme+3:]
+	if slash := strings.Index(authority, "/"); slash >= 0 {
+		authority = authority[:slash]
+	}
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return "", false
+	}
+	return authority[:at], true
+}
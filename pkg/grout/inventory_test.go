@@ -0,0 +1,77 @@
+package grout
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestURLProtocol(t *testing.T) {
+	tests := map[string]string{
+		"git@github.com:org/repo.git":       ProtocolSSH,
+		"ssh://git@github.com/org/repo.git": ProtocolSSH,
+		"git+ssh://github.com/org/repo":     ProtocolSSH,
+		"https://github.com/org/repo.git":   ProtocolHTTPS,
+		"HTTP://github.com/org/repo":        ProtocolHTTP,
+		"git://github.com/org/repo.git":     ProtocolGit,
+		"file:///srv/git/repo.git":          ProtocolFile,
+		"/srv/git/repo.git":                 ProtocolFile,
+		"../repo.git":                       ProtocolFile,
+		`C:\git\repo.git`:                   ProtocolFile,
+		"./dir:with/colon":                  ProtocolFile,
+	}
+	for url, want := range tests {
+		if got := URLProtocol(url); got != want {
+			t.Errorf("URLProtocol(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestBuildInventory(t *testing.T) {
+	repoMap := RepoMap{Repos: []LocalRepository{
+		{Name: "web", Path: "/src/web", Remotes: []Remote{
+			{Name: "origin", URLs: []string{"git@github.com:acme/web.git"}},
+			{Name: "upstream", URLs: []string{"https://github.com/acme/web", "/mirror/web.git"}},
+		}},
+		{Name: "api", Path: "/src/api", Remotes: []Remote{
+			{Name: "origin", URLs: []string{"git@github.com:acme/api.git"}},
+		}},
+		{Name: "tools", Path: "/src/tools", Remotes: []Remote{
+			{Name: "origin", URLs: []string{"git@gitlab.com:ops/tools.git"}},
+		}},
+	}}
+
+	inventory := BuildInventory(repoMap)
+	if len(inventory.Entries) != 5 {
+		t.Fatalf("BuildInventory() returned %d entries, want 5", len(inventory.Entries))
+	}
+	first := inventory.Entries[0]
+	if first.Host != "" || first.Protocol != ProtocolFile || first.Repo != "web" {
+		t.Errorf("first entry = %+v, want the unparsed file url", first)
+	}
+	api := inventory.Entries[1]
+	want := InventoryEntry{Repo: "api", Path: "/src/api", Remote: "origin", URL: "git@github.com:acme/api.git",
+		Protocol: ProtocolSSH, Host: "github.com", Org: "acme", RepoName: "api", Canonical: "github.com/acme/api", rawURL: "git@github.com:acme/api.git"}
+	if api != want {
+		t.Errorf("api entry = %+v, want %+v", api, want)
+	}
+
+	wantHosts := []HostCount{
+		{Host: "", Remotes: 1, Repos: 1, Orgs: []OrgCount{{Org: "", Remotes: 1, Repos: 1}}},
+		{Host: "github.com", Remotes: 3, Repos: 2, Orgs: []OrgCount{{Org: "acme", Remotes: 3, Repos: 2}}},
+		{Host: "gitlab.com", Remotes: 1, Repos: 1, Orgs: []OrgCount{{Org: "ops", Remotes: 1, Repos: 1}}},
+	}
+	if !reflect.DeepEqual(inventory.Hosts, wantHosts) {
+		t.Errorf("Hosts = %+v, want %+v", inventory.Hosts, wantHosts)
+	}
+
+	// web's origin and upstream are the same repo
+	wantDuplicates := []DuplicateRemotes{{Repo: "web", Path: "/src/web", Canonical: "github.com/acme/web",
+		Remotes: []string{"origin", "upstream"}, URLs: []string{"git@github.com:acme/web.git", "https://github.com/acme/web"}}}
+	if !reflect.DeepEqual(inventory.Duplicates, wantDuplicates) {
+		t.Errorf("Duplicates = %+v, want %+v", inventory.Duplicates, wantDuplicates)
+	}
+	only := inventory.OnlyDuplicates()
+	if len(only.Entries) != 2 || len(only.Hosts) != 1 || only.Hosts[0].Repos != 1 {
+		t.Errorf("OnlyDuplicates() = %+v", only)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	configLockFile = "config.lock"
+	// Held in a repo's git directory while grout applies changes to it
+	applyLockFile = "grout.lock"
+)
+
+var (
+	// Returned when another process holds a repo's config.lock
+	ErrConfigLocked = errors.New("config is locked by another process")
+	// Returned when another grout is already applying changes to a repo
+	ErrApplyInProgress = errors.New("another grout is applying changes")
+)
+
+// Returned by Apply when some repos were still locked after every retry.
+// The other repos were applied
+type LockedReposError struct {
+	Paths []string
+}
+
+func (e *LockedReposError) Error() string {
+	return fmt.Sprintf("%d repo(s) stayed locked: %s", len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+func (e *LockedReposError) Unwrap() error {
+	return ErrConfigLocked
+}
+
+// A config.lock taken the way git takes it. The new config is written to
+// the lock file and renamed over the config, so readers never see a
+// partial file
+type configLock struct {
+	file       *os.File
+	configPath string
+}
+
+func lockConfig(gitDir string) (*configLock, error) {
+	configPath := filepath.Join(gitDir, "config")
+	lockPath := filepath.Join(gitDir, configLockFile)
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s exists", ErrConfigLocked, lockPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("error locking config of %s: %w", gitDir, err)
+	}
+	return &configLock{file: file, configPath: configPath}, nil
+}
+
+// Replace the config with data, keeping the config's permissions
+func (l *configLock) commit(data []byte) error {
+	if info, err := os.Stat(l.configPath); err == nil {
+		if err := l.file.Chmod(info.Mode().Perm()); err != nil {
+			l.rollback()
+			return err
+		}
+	}
+	if _, err := l.file.Write(data); err != nil {
+		l.rollback()
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		os.Remove(l.file.Name())
+		return err
+	}
+	if err := os.Rename(l.file.Name(), l.configPath); err != nil {
+		os.Remove(l.file.Name())
+		return err
+	}
+	return nil
+}
+
+// Release the lock without changing the config
+func (l *configLock) rollback() {
+	l.file.Close()
+	os.Remove(l.file.Name())
+}
+
+// A repo's grout.lock, recording which grout process is applying to it
+type applyLock struct {
+	path string
+}
+
+// Take a repo's grout.lock. A lock left behind by a grout that is no
+// longer running on this host is taken over
+func lockApply(gitDir string) (*applyLock, error) {
+	path := filepath.Join(gitDir, applyLockFile)
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%d %s %s", os.Getpid(), hostname, time.Now().UTC().Format(time.RFC3339))
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.WriteString(owner + "\n")
+			file.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &applyLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("error locking %s: %w", gitDir, err)
+		}
+
+		data, _ := ioutil.ReadFile(path)
+		holder := strings.TrimSpace(string(data))
+		if !staleApplyLock(holder, hostname) {
+			return nil, fmt.Errorf("%w (pid, host and start of the other run: %s)", ErrApplyInProgress, holder)
+		}
+		os.Remove(path)
+	}
+	return nil, fmt.Errorf("%w: unable to take over %s", ErrApplyInProgress, path)
+}
+
+func (l *applyLock) release() {
+	os.Remove(l.path)
+}
+
+// Reports whether a grout.lock was left by a process on this host that
+// has exited. Locks from other hosts, or that can't be checked, are kept
+func staleApplyLock(holder, hostname string) bool {
+	fields := strings.Fields(holder)
+	if len(fields) < 2 || fields[1] != hostname || runtime.GOOS == "windows" {
+		return false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return true
+	}
+	// EPERM means the process exists but belongs to someone else
+	err = process.Signal(syscall.Signal(0))
+	return err != nil && !errors.Is(err, syscall.EPERM)
+}
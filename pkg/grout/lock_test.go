@@ -0,0 +1,133 @@
+package grout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+)
+
+// A repo with a single origin url and a plan that rewrites it
+func lockedRepo(t *testing.T) (string, ChangeSet) {
+	path := t.TempDir()
+	initRepo(t, path, false, config.RemoteConfig{Name: "origin", URLs: []string{remoteURL1}})
+	gitDir := filepath.Join(path, DotGit)
+	set, err := testPlanner(PlanOptions{}).Plan(context.Background(), RepoMap{Repos: []LocalRepository{{
+		Name:    "repo",
+		Path:    gitDir,
+		Remotes: []Remote{{Name: "origin", URLs: []string{remoteURL1}}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gitDir, set
+}
+
+func TestBackendsReportConfigLock(t *testing.T) {
+	for name, backend := range backendsUnderTest(t) {
+		t.Run(name, func(t *testing.T) {
+			gitDir := conformanceRepo(t, false)
+			if err := ioutil.WriteFile(filepath.Join(gitDir, configLockFile), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := backend.ReplaceURL(context.Background(), gitDir, "upstream", remoteURL3, remoteURL1)
+			if !errors.Is(err, ErrConfigLocked) {
+				t.Errorf("ReplaceURL() error = %v, want %v", err, ErrConfigLocked)
+			}
+		})
+	}
+}
+
+func TestApplyReportsLockedRepos(t *testing.T) {
+	gitDir, set := lockedRepo(t)
+	lockPath := filepath.Join(gitDir, configLockFile)
+	if err := ioutil.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := ioutil.ReadFile(filepath.Join(gitDir, "config"))
+
+	err := NewApplier(ApplyOptions{LockRetries: 2, LockBackoff: time.Millisecond}).Apply(context.Background(), set)
+	var locked *LockedReposError
+	if !errors.As(err, &locked) || len(locked.Paths) != 1 || locked.Paths[0] != gitDir {
+		t.Fatalf("Apply() error = %v, want the repo reported as locked", err)
+	}
+	if after, _ := ioutil.ReadFile(filepath.Join(gitDir, "config")); string(after) != string(before) {
+		t.Error("Expected a locked config to be left alone")
+	}
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Error("Expected the other process's config.lock to be left in place")
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, applyLockFile)); !os.IsNotExist(err) {
+		t.Error("Expected grout.lock to be released")
+	}
+}
+
+func TestApplyRetriesConfigLock(t *testing.T) {
+	gitDir, set := lockedRepo(t)
+	lockPath := filepath.Join(gitDir, configLockFile)
+	if err := ioutil.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		os.Remove(lockPath)
+	}()
+
+	err := NewApplier(ApplyOptions{LockRetries: 8, LockBackoff: 5 * time.Millisecond}).Apply(context.Background(), set)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	remotes, err := GoGitBackend{}.Remotes(context.Background(), gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remotes[0].URLs[0] != set.Plans[0].Changes[0].NewURLs[0] {
+		t.Errorf("origin urls = %v after the lock was released", remotes[0].URLs)
+	}
+}
+
+func TestApplyRefusesConcurrentApply(t *testing.T) {
+	gitDir, set := lockedRepo(t)
+	hostname, _ := os.Hostname()
+	// this test process stands in for a grout that is still running
+	holder := fmt.Sprintf("%d %s %s\n", os.Getpid(), hostname, time.Now().UTC().Format(time.RFC3339))
+	if err := ioutil.WriteFile(filepath.Join(gitDir, applyLockFile), []byte(holder), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := NewApplier(ApplyOptions{}).Apply(context.Background(), set)
+	if !errors.Is(err, ErrApplyInProgress) {
+		t.Fatalf("Apply() error = %v, want %v", err, ErrApplyInProgress)
+	}
+	remotes, _ := GoGitBackend{}.Remotes(context.Background(), gitDir)
+	if remotes[0].URLs[0] != remoteURL1 {
+		t.Error("Expected no changes while another grout holds the repo")
+	}
+}
+
+func TestApplyTakesOverStaleLock(t *testing.T) {
+	exited := exec.Command("go", "version")
+	if err := exited.Run(); err != nil {
+		t.Skip("unable to start a process to stand in for a finished grout")
+	}
+	gitDir, set := lockedRepo(t)
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%d %s %s\n", exited.Process.Pid, hostname, time.Now().UTC().Format(time.RFC3339))
+	if err := ioutil.WriteFile(filepath.Join(gitDir, applyLockFile), []byte(holder), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewApplier(ApplyOptions{}).Apply(context.Background(), set); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, applyLockFile)); !os.IsNotExist(err) {
+		t.Error("Expected grout.lock to be released")
+	}
+}
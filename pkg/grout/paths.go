@@ -0,0 +1,135 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	formatcfg "github.com/go-git/go-git/v5/plumbing/format/config"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Expand environment variables and a leading ~ in a path, and make it
+// absolute relative to the working directory
+func NormalizePath(path string) (string, error) {
+	expanded, err := homedir.Expand(os.ExpandEnv(strings.TrimSpace(path)))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(expanded)
+}
+
+// Resolve symlinks in an absolute path so the same repo reached through
+// different links is only mapped once. Paths that can't be resolved are
+// returned unchanged
+func CanonicalPath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
+
+// Normalize each search directory, checking that each one exists
+func ResolveDirectories(dirs []string) ([]string, error) {
+	resolvedDirs := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		resolved, err := NormalizePath(dir)
+		if err == nil {
+			var info os.FileInfo
+			if info, err = os.Stat(resolved); err == nil && !info.IsDir() {
+				err = errors.New("not a directory")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid search directory %s: %w", dir, err)
+		}
+		resolvedDirs = append(resolvedDirs, resolved)
+	}
+	return resolvedDirs, nil
+}
+
+// Read repo paths from a list with one path per line. Blank lines and
+// lines starting with # are ignored
+func ReadRepoList(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
+
+// Find the git directory of the repo containing dir, following gitdir
+// files used by worktrees and submodules. Returns "" if dir isn't in a repo
+func FindGitDir(dir string) string {
+	for {
+		candidate := filepath.Join(dir, DotGit)
+		if info, err := os.Stat(candidate); err == nil {
+			if info.IsDir() {
+				return candidate
+			}
+			if data, err := ioutil.ReadFile(candidate); err == nil {
+				gitDir := strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+				if !filepath.IsAbs(gitDir) {
+					gitDir = filepath.Join(dir, gitDir)
+				}
+				return gitDir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Read a git config file, returning an empty config if it doesn't exist
+func ReadGitConfigFile(path string) (*formatcfg.Config, error) {
+	cfg := formatcfg.New()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := formatcfg.NewDecoder(f).Decode(cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Interpret a git config boolean
+func IsGitTrue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Write a ChangeSet to a json plan file, with every url redacted
+func WritePlanFile(set ChangeSet, filename string) error {
+	jsonStr, err := json.MarshalIndent(set.Redacted(), "", twoSpaces)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, jsonStr, os.ModePerm)
+}
+
+// Read a ChangeSet from a json plan file, rejecting it as DecodePlan does
+// if it doesn't match the schema
+func ReadPlanFile(filename string) (ChangeSet, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	return DecodePlan(data)
+}
+
+// Strictly decode a plan, rejecting any field that is not part of the
+// ChangeSet schema
+func DecodePlan(data []byte) (ChangeSet, error) {
+	var set ChangeSet
+	if len(bytes.TrimSpace(data)) == 0 {
+		return ChangeSet{}, errors.New("plan file is empty")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&set); err != nil {
+		return ChangeSet{}, fmt.Errorf("plan does not match schema: %w", err)
+	}
+	return set, nil
+}
+
+// Check a ChangeSet for internal consistency and against the current
+// filesystem, reading remotes with the given backend (go-git if nil).
+// Nothing is modified; every problem found is returned.
+func Validate(ctx context.Context, backend Backend, set ChangeSet) []error {
+	backend = backendOrDefault(backend)
+	var errs []error
+
+	count := 0
+	for i, plan := range set.Plans {
+		label := plan.Repo.Path
+		if len(label) == 0 {
+			label = fmt.Sprintf("plans[%d]", i)
+			errs = append(errs, fmt.Errorf("%s: repo path is empty", label))
+			continue
+		}
+
+		var remotes map[string]Remote
+		if found, err := backend.Remotes(ctx, plan.Repo.Path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: unable to read remotes: %w", label, err))
+		} else {
+			remotes = map[string]Remote{}
+			for _, remote := range found {
+				remotes[remote.Name] = remote
+			}
+		}
+
+		if len(plan.Changes) == 0 {
+			errs = append(errs, fmt.Errorf("%s: plan has no changes", label))
+		}
+		for _, change := range plan.Changes {
+			if len(change.Name) == 0 {
+				errs = append(errs, fmt.Errorf("%s: change has no remote name", label))
+				continue
+			}
+			if len(change.CurrentURLs) != len(change.NewURLs) {
+				errs = append(errs, fmt.Errorf("%s: remote %s has %d current url(s) but %d new url(s)",
+					label, change.Name, len(change.CurrentURLs), len(change.NewURLs)))
+			}
+			if !plan.Excluded && !change.Excluded {
+				count += len(change.NewURLs)
+			}
+
+			for _, url := range append(append([]string{}, change.CurrentURLs...), change.NewURLs...) {
+				if !ValidRemoteURL(url) {
+					errs = append(errs, fmt.Errorf("%s: remote %s: unable to parse url %q", label, change.Name, RedactURL(url)))
+				}
+			}
+
+			if remotes == nil {
+				continue
+			}
+			remote, ok := remotes[change.Name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: remote %s does not exist", label, change.Name))
+				continue
+			}
+			for _, url := range change.CurrentURLs {
+				if !containsString(remote.URLs, url) && !containsString(redactURLs(remote.URLs), url) {
+					errs = append(errs, fmt.Errorf("%s: remote %s no longer has url %s", label, change.Name, RedactURL(url)))
+				}
+			}
+		}
+	}
+
+	if count != set.Count {
+		errs = append(errs, fmt.Errorf("plan count is %d but the plans contain %d change(s)", set.Count, count))
+	}
+	return errs
+}
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Recalculate the change count of a ChangeSet, leaving out any excluded
+// repos and changes
+func (set *ChangeSet) Recount() {
+	set.Count = 0
+	for _, plan := range set.Plans {
+		if plan.Excluded {
+			continue
+		}
+		for _, change := range plan.Changes {
+			if !change.Excluded {
+				set.Count += len(change.NewURLs)
+			}
+		}
+	}
+}
+
+// Selectors for repos and remotes to exclude from, or re-include in, a plan
+type EditOptions struct {
+	ExcludeRepos   []string
+	IncludeRepos   []string
+	ExcludeRemotes []string
+	IncludeRemotes []string
+}
+
+// Exclude or re-include repos and changes matching the given selectors.
+// Exclusions are applied before inclusions, and the count is recalculated
+func (set *ChangeSet) Edit(opts EditOptions) {
+	for i := range set.Plans {
+		plan := &set.Plans[i]
+		for _, pattern := range opts.ExcludeRepos {
+			if RepoMatches(plan.Repo, pattern) {
+				plan.Excluded = true
+			}
+		}
+		for _, pattern := range opts.IncludeRepos {
+			if RepoMatches(plan.Repo, pattern) {
+				plan.Excluded = false
+			}
+		}
+		for j := range plan.Changes {
+			change := &plan.Changes[j]
+			for _, selector := range opts.ExcludeRemotes {
+				if ChangeMatches(plan.Repo, *change, selector) {
+					change.Excluded = true
+				}
+			}
+			for _, selector := range opts.IncludeRemotes {
+				if ChangeMatches(plan.Repo, *change, selector) {
+					change.Excluded = false
+				}
+			}
+		}
+	}
+	set.Recount()
+}
+
+// Reports whether a repo matches a selector. A selector is a glob matched
+// against the repo name, its .git path or its working directory
+func RepoMatches(repo LocalRepository, pattern string) bool {
+	if pattern == repo.Name {
+		return true
+	}
+	candidates := []string{repo.Name, repo.Path}
+	if filepath.Base(repo.Path) == DotGit {
+		candidates = append(candidates, filepath.Dir(repo.Path))
+	}
+	for _, candidate := range candidates {
+		if matched, _ := filepath.Match(pattern, candidate); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a change matches a remote selector of the form
+// "remote" or "remote@repo-selector"
+func ChangeMatches(repo LocalRepository, change RemoteChange, selector string) bool {
+	remotePattern, repoPattern := selector, ""
+	if i := strings.Index(selector, "@"); i >= 0 {
+		remotePattern, repoPattern = selector[:i], selector[i+1:]
+	}
+	if matched, _ := filepath.Match(remotePattern, change.Name); !matched {
+		return false
+	}
+	return len(repoPattern) == 0 || RepoMatches(repo, repoPattern)
+}
@@ -0,0 +1,494 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A rewrite rule. Empty Set fields leave that part of the url unchanged,
+// and an empty FindOrg matches every org on the host
+type Rule struct {
+	FindURL string `mapstructure:"find-url"`
+	SetURL  string `mapstructure:"set-url"`
+	FindOrg string `mapstructure:"find-org"`
+	SetOrg  string `mapstructure:"set-org"`
+}
+
+// The host a rule moves urls to. Rules without a SetURL keep the host
+func (r Rule) newHost() string {
+	if len(r.SetURL) == 0 {
+		return r.FindURL
+	}
+	return r.SetURL
+}
+
+func (r Rule) String() string {
+	from, to := r.FindURL, r.SetURL
+	if len(r.FindOrg) > 0 {
+		from += "/" + r.FindOrg
+	}
+	if len(to) == 0 {
+		to = r.FindURL
+	}
+	if len(r.SetOrg) > 0 {
+		to += "/" + r.SetOrg
+	}
+	return from + " -> " + to
+}
+
+// What a Planner does with the .git suffix of the urls it rewrites
+type GitSuffixPolicy string
+
+const (
+	// Keep each url's suffix, or lack of one, as it was
+	GitSuffixPreserve GitSuffixPolicy = "preserve"
+	// Give every rewritten url a .git suffix
+	GitSuffixAlways GitSuffixPolicy = "always"
+	// Take the .git suffix off every rewritten url
+	GitSuffixNever GitSuffixPolicy = "never"
+)
+
+// Look up a .git suffix policy by name. An empty name preserves suffixes
+func ParseGitSuffixPolicy(name string) (GitSuffixPolicy, error) {
+	switch policy := GitSuffixPolicy(strings.ToLower(name)); policy {
+	case "", GitSuffixPreserve:
+		return GitSuffixPreserve, nil
+	case GitSuffixAlways, GitSuffixNever:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown .git suffix policy %q, expected %s, %s or %s",
+		name, GitSuffixAlways, GitSuffixNever, GitSuffixPreserve)
+}
+
+// Apply the policy to a repo name or url
+func (policy GitSuffixPolicy) apply(repo string) string {
+	switch policy {
+	case GitSuffixAlways:
+		if !strings.HasSuffix(repo, DotGit) {
+			return strings.TrimSuffix(repo, "/") + DotGit
+		}
+	case GitSuffixNever:
+		return strings.TrimSuffix(repo, DotGit)
+	}
+	return repo
+}
+
+// How a Planner rewrites remote urls. The find/set fields make up the
+// primary rule, which is tried before any of Rules
+type PlanOptions struct {
+	FindURL string
+	SetURL  string
+	FindOrg string
+	SetOrg  string
+	// Scheme used for rewritten http(s) urls
+	RemoteType string
+	// Orgs to rename when a rule doesn't set one, old to new
+	OrgMap map[string]string
+	Rules  []Rule
+	// Look for commits, branches and stashes that haven't been pushed to
+	// the remotes being changed, and record them on each plan
+	CheckUnpushed bool
+	// Exclude repos with unpushed work from the plan. Implies CheckUnpushed
+	ExcludeUnpushed bool
+	// Drop the user and password of rewritten http(s) urls instead of
+	// carrying them over to the new url
+	StripCredentials bool
+	// What to do with the .git suffix of rewritten urls. Defaults to
+	// preserving it
+	GitSuffix GitSuffixPolicy
+	// Don't move urls to new hosts. Instead apply GitSuffix to the urls
+	// already on one of the hosts the rules move urls to
+	NormalizeOnly bool
+	// Don't move urls to new hosts. Instead move http urls to https and
+	// git:// urls to GitProtocolTo, on any host
+	UpgradeProtocol bool
+	// What git:// urls are upgraded or moved to, https (the default) or ssh
+	GitProtocolTo string
+	// Resolves the host aliases of scp-like urls, so urls using an alias
+	// match rules for the real host. Nil leaves aliases unresolved
+	SSHConfig *SSHConfig
+	// Write the real host, user and port of an alias into rewritten urls.
+	// Otherwise the alias is kept as long as it still reaches the new host
+	ReplaceSSHAliases bool
+	// Reads each repo's branches for the unpushed work check. Defaults to
+	// go-git
+	Backend Backend
+}
+
+// A Planner works out the remote changes for a RepoMap
+type Planner struct {
+	opts PlanOptions
+}
+
+func NewPlanner(opts PlanOptions) *Planner {
+	opts.Backend = backendOrDefault(opts.Backend)
+	return &Planner{opts: opts}
+}
+
+// Build a ChangeSet holding a plan for each repo with remotes to change.
+// Skipped repos are carried over so they can be reported with the plan
+func (p *Planner) Plan(ctx context.Context, repoMap RepoMap) (ChangeSet, error) {
+	var set ChangeSet
+	for _, repo := range repoMap.Repos {
+		if err := ctx.Err(); err != nil {
+			return ChangeSet{}, err
+		}
+		plan := p.PlanRepo(repo)
+		if !plan.HasChanges {
+			continue
+		}
+		if p.opts.CheckUnpushed || p.opts.ExcludeUnpushed {
+			if err := p.checkUnpushed(ctx, &plan); err != nil {
+				set.Errors = append(set.Errors, err)
+			}
+		}
+		set.Plans = append(set.Plans, plan)
+	}
+	set.Recount()
+	set.Skipped = append(set.Skipped, repoMap.Skipped...)
+	set.Broken = findBrokenRemotes(repoMap)
+	return set, nil
+}
+
+// Every remote url using the git:// protocol, which sends code unencrypted
+// and unauthenticated and which the major hosts have stopped serving
+func findBrokenRemotes(repoMap RepoMap) []BrokenRemote {
+	var broken []BrokenRemote
+	for _, repo := range repoMap.Repos {
+		for _, remote := range repo.Remotes {
+			for _, url := range remote.URLs {
+				if URLProtocol(url) == ProtocolGit {
+					broken = append(broken, BrokenRemote{Name: repo.Name, Path: repo.Path, Remote: remote.Name,
+						URL: RedactURL(url), Reason: "the git:// protocol is unauthenticated and no longer served by most hosts"})
+				}
+			}
+		}
+	}
+	return broken
+}
+
+// Work out the changes for a single repo, with one change per url
+func (p *Planner) PlanRepo(repo LocalRepository) RepoPlan {
+	plan := RepoPlan{Repo: repo}
+	for _, remote := range repo.Remotes {
+		currentURLs := remote.URLs
+		newURLs, _ := p.RewriteURLs(currentURLs)
+		for i := 0; i < len(newURLs); i++ {
+			if currentURLs[i] == newURLs[i] {
+				continue
+			}
+			change := RemoteChange{
+				Name:        remote.Name,
+				CurrentURLs: []string{currentURLs[i]},
+				NewURLs:     []string{newURLs[i]},
+			}
+			if alias, ok := p.sshAlias(currentURLs[i]); ok {
+				change.SSHAlias = &alias
+			}
+			plan.Changes = append(plan.Changes, change)
+			plan.HasChanges = true
+		}
+	}
+	return plan
+}
+
+// Record the work a repo hasn't pushed to the remotes being changed,
+// excluding the repo if ExcludeUnpushed is set
+func (p *Planner) checkUnpushed(ctx context.Context, plan *RepoPlan) error {
+	var remotes []string
+	for _, change := range plan.Changes {
+		if !containsString(remotes, change.Name) {
+			remotes = append(remotes, change.Name)
+		}
+	}
+	work, err := p.opts.Backend.UnpushedWork(ctx, plan.Repo.Path, remotes)
+	if err != nil {
+		return fmt.Errorf("%s: unable to check for unpushed work: %w", plan.Repo.Path, err)
+	}
+	if work.Empty() {
+		return nil
+	}
+	plan.UnpushedWork = &work
+	if p.opts.ExcludeUnpushed {
+		plan.Excluded = true
+	}
+	return nil
+}
+
+// Build a new remote url for each url matching a rule, or with
+// NormalizeOnly, for each url already on a rule's new host. Urls that
+// don't match or can't be parsed are returned unchanged, so the new urls
+// always line up with the current ones. Also returns the number of urls
+// that changed
+func (p *Planner) RewriteURLs(urls []string) ([]string, int) {
+	var newRemoteURLs []string
+	count := 0
+
+	for _, url := range urls {
+		if p.opts.UpgradeProtocol {
+			newRemote := p.upgradeProtocol(url)
+			newRemoteURLs = append(newRemoteURLs, newRemote)
+			if newRemote != url {
+				count++
+			}
+			continue
+		}
+		splitUrl, alias, ok := p.parseURL(url)
+		if !ok {
+			// Malformed or local remote url, kept as it is so that the new
+			// urls line up with the current ones
+			newRemoteURLs = append(newRemoteURLs, url)
+			continue
+		}
+		if p.opts.NormalizeOnly {
+			newRemote := url
+			if p.onNewHost(splitUrl) {
+				newRemote = p.opts.GitSuffix.apply(url)
+			}
+			newRemoteURLs = append(newRemoteURLs, newRemote)
+			if newRemote != url {
+				count++
+			}
+			continue
+		}
+		rule, ok := p.matchRule(splitUrl)
+		if !ok {
+			newRemoteURLs = append(newRemoteURLs, url)
+			continue
+		}
+
+		if len(rule.SetOrg) > 0 {
+			splitUrl.Org = rule.SetOrg
+		} else if mappedOrg, ok := p.mapOrg(splitUrl.Org); ok {
+			splitUrl.Org = mappedOrg
+		}
+		splitUrl.Repo = p.opts.GitSuffix.apply(splitUrl.Repo)
+		setURL := rule.newHost()
+
+		newRemote := url
+		if alias != nil {
+			newRemote = p.aliasURL(url, *alias, setURL, splitUrl)
+		} else if rebuilt, ok := p.rebuildURL(url, setURL, splitUrl); ok {
+			newRemote = rebuilt
+		}
+		newRemoteURLs = append(newRemoteURLs, newRemote)
+
+		if newRemote != url {
+			count++
+		}
+	}
+	return newRemoteURLs, count
+}
+
+// Split a url into the host and org/repo path matched against the rules,
+// the same way CanonicalURL reads it: users are left out and a scheme's
+// default port is dropped. Type is the url's protocol. An scp-like url
+// using an ssh host alias is split on the alias's real host, which is also
+// returned. Returns false for local paths and paths that aren't org/repo
+func (p *Planner) parseURL(url string) (SplitUrl, *SSHAlias, bool) {
+	host, path, ok := splitHostPath(url)
+	if !ok {
+		return SplitUrl{}, nil, false
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(host) == 0 || len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return SplitUrl{}, nil, false
+	}
+	splitUrl := SplitUrl{Type: URLProtocol(url), BaseURL: host, Org: parts[0], Repo: parts[1]}
+	if alias, ok := p.sshAlias(url); ok {
+		splitUrl.BaseURL = alias.HostName
+		return splitUrl, &alias, true
+	}
+	return splitUrl, nil, true
+}
+
+// What the host of an scp-like url resolves to in the ssh config, if it
+// is an alias
+func (p *Planner) sshAlias(url string) (SSHAlias, bool) {
+	if p.opts.SSHConfig == nil || strings.Contains(url, "://") || URLProtocol(url) != ProtocolSSH {
+		return SSHAlias{}, false
+	}
+	host, _, ok := splitHostPath(url)
+	if !ok {
+		return SSHAlias{}, false
+	}
+	return p.opts.SSHConfig.Resolve(host)
+}
+
+// Build the new url for a url using an ssh alias. The alias is kept if it
+// still reaches the new host, unless ReplaceSSHAliases is set. Otherwise
+// the url gets the real host, with the alias's user and, on the same
+// host, its port
+func (p *Planner) aliasURL(url string, alias SSHAlias, setURL string, splitUrl SplitUrl) string {
+	path := splitUrl.Org + "/" + splitUrl.Repo
+	sameHost := strings.EqualFold(setURL, alias.HostName)
+	user := ""
+	if at := strings.Index(url, "@"); at >= 0 && at < strings.Index(url, ":") {
+		user = url[:at]
+	}
+	if sameHost && !p.opts.ReplaceSSHAliases {
+		if len(user) > 0 {
+			return user + "@" + alias.Alias + ":" + path
+		}
+		return alias.Alias + ":" + path
+	}
+
+	if len(user) == 0 {
+		user = alias.User
+	}
+	if len(user) == 0 {
+		user = justGit
+	}
+	if sameHost && len(alias.Port) > 0 && alias.Port != defaultPorts[ProtocolSSH] {
+		return fmt.Sprintf("%s://%s@%s:%s/%s", ProtocolSSH, user, setURL, alias.Port, path)
+	}
+	return fmt.Sprintf("%s@%s:%s", user, setURL, path)
+}
+
+// Build a url's new url on a host, keeping its form: scp-like urls keep
+// their user, ssh:// urls their scheme and user, and http(s) urls move to
+// RemoteType with their user info unless StripCredentials is set. git://
+// urls move to ssh if GitProtocolTo is ssh, otherwise to RemoteType, as
+// new hosts don't serve the git protocol. Returns false for urls of any
+// other kind
+func (p *Planner) rebuildURL(url, host string, splitUrl SplitUrl) (string, bool) {
+	path := splitUrl.Org + "/" + splitUrl.Repo
+	i := strings.Index(url, "://")
+	if i < 0 {
+		if URLProtocol(url) != ProtocolSSH {
+			return "", false
+		}
+		userHost := url[:strings.Index(url, ":")]
+		if at := strings.LastIndex(userHost, "@"); at >= 0 {
+			return userHost[:at+1] + host + ":" + path, true
+		}
+		return host + ":" + path, true
+	}
+
+	scheme := url[:i]
+	switch URLProtocol(url) {
+	case ProtocolHTTP, ProtocolHTTPS:
+		if len(p.opts.RemoteType) > 0 {
+			scheme = p.opts.RemoteType
+		}
+		return scheme + "://" + p.userinfo(url) + host + "/" + path, true
+	case ProtocolGit:
+		if p.opts.GitProtocolTo == ProtocolSSH {
+			return justGit + "@" + host + ":" + path, true
+		}
+		scheme = ProtocolHTTPS
+		if len(p.opts.RemoteType) > 0 {
+			scheme = p.opts.RemoteType
+		}
+		return scheme + "://" + host + "/" + path, true
+	case ProtocolSSH:
+		user := ""
+		if userinfo, ok := urlUserinfo(url); ok {
+			user = userinfo + "@"
+		}
+		return scheme + "://" + user + host + "/" + path, true
+	}
+	return "", false
+}
+
+// Move an http url to https, or a git:// url to https or ssh, keeping
+// its host and path. Other urls are returned as they are
+func (p *Planner) upgradeProtocol(url string) string {
+	protocol := URLProtocol(url)
+	if protocol != ProtocolHTTP && protocol != ProtocolGit {
+		return url
+	}
+	host, path, ok := splitHostPath(url)
+	if !ok {
+		return url
+	}
+
+	var upgraded string
+	if protocol == ProtocolHTTP {
+		upgraded = ProtocolHTTPS + "://" + p.userinfo(url) + host + path
+	} else {
+		// the git daemon's port means nothing to https or ssh
+		if colon := strings.LastIndex(host, ":"); colon >= 0 && !strings.Contains(host[colon:], "]") {
+			host = host[:colon]
+		}
+		if p.opts.GitProtocolTo == ProtocolSSH {
+			upgraded = justGit + "@" + host + ":" + strings.TrimPrefix(path, "/")
+		} else {
+			upgraded = ProtocolHTTPS + "://" + host + path
+		}
+	}
+	return p.opts.GitSuffix.apply(upgraded)
+}
+
+// Reports whether a url is on a host one of the rules moves urls to
+func (p *Planner) onNewHost(splitUrl SplitUrl) bool {
+	for _, rule := range p.rules() {
+		if strings.EqualFold(splitUrl.BaseURL, rule.newHost()) {
+			return true
+		}
+	}
+	return false
+}
+
+// The user info of a url to carry over to its new url, with its trailing @
+func (p *Planner) userinfo(url string) string {
+	userinfo, ok := urlUserinfo(url)
+	if !ok || p.opts.StripCredentials {
+		return ""
+	}
+	return userinfo + "@"
+}
+
+// The find/set options as a rule, followed by the other rules
+func (p *Planner) rules() []Rule {
+	return append([]Rule{{
+		FindURL: p.opts.FindURL,
+		SetURL:  p.opts.SetURL,
+		FindOrg: p.opts.FindOrg,
+		SetOrg:  p.opts.SetOrg,
+	}}, p.opts.Rules...)
+}
+
+// Look an org up in OrgMap, ignoring case if it isn't found as written
+func (p *Planner) mapOrg(org string) (string, bool) {
+	if mapped, ok := p.opts.OrgMap[org]; ok {
+		return mapped, true
+	}
+	for from, to := range p.opts.OrgMap {
+		if strings.EqualFold(from, org) {
+			return to, true
+		}
+	}
+	return "", false
+}
+
+// Find the first rule that targets the url's host and org, ignoring case.
+// The find/set options are always tried first, followed by any other rules
+func (p *Planner) matchRule(splitUrl SplitUrl) (Rule, bool) {
+	for _, rule := range p.rules() {
+		if !strings.EqualFold(splitUrl.BaseURL, rule.FindURL) {
+			continue
+		}
+		if len(rule.FindOrg) > 0 && !strings.EqualFold(rule.FindOrg, splitUrl.Org) {
+			continue
+		}
+		return rule, true
+	}
+	return Rule{}, false
+}
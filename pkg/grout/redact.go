@@ -0,0 +1,173 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// What a password or token is replaced with when a url is redacted
+const RedactedSecret = "****"
+
+// Prefixes of access tokens that are commonly put in a url as the user
+var tokenPrefixes = []string{"ghp_", "gho_", "ghu_", "ghs_", "github_pat_", "glpat-", "x-access-token"}
+
+// The user info of every url with a scheme in a piece of text
+var userinfoPattern = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://)([^/@\s'"]+)@`)
+
+// Replace the password, or a user that looks like an access token, in
+// every url in text with RedactedSecret. Works on single urls as well as
+// messages that contain them, and leaves plain users alone
+func RedactURL(text string) string {
+	return userinfoPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := userinfoPattern.FindStringSubmatch(match)
+		return parts[1] + redactUserinfo(parts[2]) + "@"
+	})
+}
+
+// A user that looks like a token hides the whole user info, as the
+// password after it is then usually a placeholder such as x-oauth-basic
+func redactUserinfo(userinfo string) string {
+	user := userinfo
+	colon := strings.Index(userinfo, ":")
+	if colon >= 0 {
+		user = userinfo[:colon]
+	}
+	for _, prefix := range tokenPrefixes {
+		if strings.HasPrefix(user, prefix) {
+			return RedactedSecret
+		}
+	}
+	if colon >= 0 {
+		return user + ":" + RedactedSecret
+	}
+	return userinfo
+}
+
+// Reports whether a url carries a password, or a user that looks like an
+// access token
+func hasCredentials(url string) bool {
+	return RedactURL(url) != url
+}
+
+// Reports whether a url has had its credentials redacted
+func isRedacted(url string) bool {
+	userinfo, ok := urlUserinfo(url)
+	return ok && strings.Contains(userinfo, RedactedSecret)
+}
+
+// A copy of the ChangeSet with every url redacted, as written to plan files
+func (set ChangeSet) Redacted() ChangeSet {
+	redacted := set
+	redacted.Plans = make([]RepoPlan, len(set.Plans))
+	for i, plan := range set.Plans {
+		plan.Repo.Remotes = make([]Remote, len(set.Plans[i].Repo.Remotes))
+		for j, remote := range set.Plans[i].Repo.Remotes {
+			remote.URLs = redactURLs(remote.URLs)
+			plan.Repo.Remotes[j] = remote
+		}
+		plan.Changes = make([]RemoteChange, len(set.Plans[i].Changes))
+		for j, change := range set.Plans[i].Changes {
+			change.CurrentURLs = redactURLs(change.CurrentURLs)
+			change.NewURLs = redactURLs(change.NewURLs)
+			plan.Changes[j] = change
+		}
+		redacted.Plans[i] = plan
+	}
+	return redacted
+}
+
+func redactURLs(urls []string) []string {
+	if urls == nil {
+		return nil
+	}
+	redacted := make([]string, len(urls))
+	for i, url := range urls {
+		redacted[i] = RedactURL(url)
+	}
+	return redacted
+}
+
+// Put back the credentials a plan file's urls were redacted of, taking
+// them from the repo's current remotes. A redacted new url is matched
+// against the remote too, in case the plan has already been applied.
+// Plans without redacted urls are returned as they are
+func unredactPlan(ctx context.Context, backend Backend, plan RepoPlan) (RepoPlan, error) {
+	redacted := false
+	for _, change := range plan.Changes {
+		for _, url := range append(append([]string{}, change.CurrentURLs...), change.NewURLs...) {
+			redacted = redacted || isRedacted(url)
+		}
+	}
+	if !redacted {
+		return plan, nil
+	}
+
+	remotes, err := backend.Remotes(ctx, plan.Repo.Path)
+	if err != nil {
+		return plan, err
+	}
+	changes := make([]RemoteChange, len(plan.Changes))
+	for i, change := range plan.Changes {
+		change.CurrentURLs = append([]string{}, change.CurrentURLs...)
+		change.NewURLs = append([]string{}, change.NewURLs...)
+		for j := 0; j < len(change.CurrentURLs) && j < len(change.NewURLs); j++ {
+			current, next := change.CurrentURLs[j], change.NewURLs[j]
+			if !isRedacted(current) && !isRedacted(next) {
+				continue
+			}
+			if live, ok := findRedacted(remotes, change.Name, current); ok {
+				change.CurrentURLs[j] = live
+				change.NewURLs[j] = swapUserinfo(next, current, live)
+			} else if live, ok := findRedacted(remotes, change.Name, next); ok {
+				change.NewURLs[j] = live
+			} else {
+				return plan, fmt.Errorf("remote %s has no url matching the redacted url %s", change.Name, current)
+			}
+		}
+		changes[i] = change
+	}
+	plan.Changes = changes
+	return plan, nil
+}
+
+// Give url the user info of live if it has the same user info as from
+func swapUserinfo(url, from, live string) string {
+	fromInfo, _ := urlUserinfo(from)
+	liveInfo, _ := urlUserinfo(live)
+	if info, ok := urlUserinfo(url); !ok || info != fromInfo {
+		return url
+	}
+	return strings.Replace(url, fromInfo+"@", liveInfo+"@", 1)
+}
+
+// The url of a remote that redacts to the given url
+func findRedacted(remotes []Remote, name, redacted string) (string, bool) {
+	for _, remote := range remotes {
+		if remote.Name != name {
+			continue
+		}
+		for _, url := range remote.URLs {
+			if RedactURL(url) == redacted {
+				return url, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,300 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where a Scanner looks for repos
+type ScanOptions struct {
+	// Directories searched for repos, usually from ResolveDirectories
+	Directories []string
+	// The search directories as the user gave them, in the same order as
+	// Directories. Repos found under one are given a GivenPath under it
+	DirectoryNames []string
+	// Repos mapped without searching. Each may be a working directory, a
+	// .git directory or a bare repo
+	RepoPaths []string
+	// Repos mapped without searching that ignore the Exclude patterns
+	ForcedRepos []string
+	// Globs matched against a repo's name, .git path or working directory
+	Exclude []string
+	// Called for every path visited while searching, with the number of
+	// repos mapped so far
+	Progress func(path string, info os.FileInfo, found int)
+	// Reads each repo's remotes. Defaults to go-git
+	Backend Backend
+}
+
+// A Scanner maps the repos found with its options
+type Scanner struct {
+	opts ScanOptions
+}
+
+func NewScanner(opts ScanOptions) *Scanner {
+	opts.Backend = backendOrDefault(opts.Backend)
+	return &Scanner{opts: opts}
+}
+
+// Walk each search directory for repos, then add the listed and forced
+// repos. Repos found more than once are only mapped once. Problems with
+// single paths are collected in RepoMap.Errors; the returned error is only
+// set if a walk fails or the context is done
+func (s *Scanner) Scan(ctx context.Context) (RepoMap, error) {
+	var repoMap RepoMap
+	var walkErr error
+	for i, dir := range s.opts.Directories {
+		name := dir
+		if i < len(s.opts.DirectoryNames) {
+			name = s.opts.DirectoryNames[i]
+		}
+		// Walk doesn't follow a symlinked root unless it ends in a separator
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			dir += string(filepath.Separator)
+		}
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if s.opts.Progress != nil && err == nil {
+				s.opts.Progress(path, info, len(repoMap.Repos))
+			}
+			given := path
+			if rel, err := filepath.Rel(dir, path); err == nil {
+				given = filepath.Join(name, rel)
+			}
+			return s.mapRepository(ctx, path, given, info, err, &repoMap)
+		})
+		if err := ctx.Err(); err != nil {
+			return repoMap, err
+		}
+		if err != nil && walkErr == nil {
+			walkErr = err
+		}
+	}
+	s.mapRepositoryList(ctx, s.opts.RepoPaths, false, &repoMap)
+	s.mapRepositoryList(ctx, s.opts.ForcedRepos, true, &repoMap)
+	return repoMap, walkErr
+}
+
+// Searches for a git repo in a given directory path
+// If found, the repo is added to the RepoMap
+func (s *Scanner) mapRepository(ctx context.Context, path, given string, info os.FileInfo, err error, repoMap *RepoMap) error {
+	if err != nil {
+		repoMap.Errors = append(repoMap.Errors, err)
+		return nil
+	}
+	if info.Name() == DotGit && !info.IsDir() {
+		gitDir, err := resolveGitFile(path)
+		if err != nil {
+			repoMap.Errors = append(repoMap.Errors, err)
+			return nil
+		}
+		s.mapGitDir(ctx, gitDir, gitDir, false, repoMap)
+	} else if info.Name() == DotGit {
+		s.mapGitDir(ctx, path, given, false, repoMap)
+	} else if info.IsDir() && isBareRepository(path) {
+		s.mapGitDir(ctx, path, given, false, repoMap)
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// Find the git directory a .git file points at. Worktrees resolve to the
+// git directory of their main repo, so they are mapped with it
+func resolveGitFile(path string) (string, error) {
+	// FindGitDir moves on to the parent directory if the file can't be read
+	if _, err := ioutil.ReadFile(path); err != nil {
+		return "", err
+	}
+	gitDir := FindGitDir(filepath.Dir(path))
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%s does not point at a git directory", path)
+	}
+	return commonGitDir(gitDir), nil
+}
+
+// The git directory a worktree shares with its main repo, named by the
+// commondir file in its own git directory. Other git directories are
+// returned as they are
+func commonGitDir(gitDir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	common := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gitDir, common)
+	}
+	return filepath.Clean(common)
+}
+
+// Add the repo at a git directory to the RepoMap, or record why it was
+// skipped. given is the same directory as the user gave it. Forced repos
+// ignore the configured exclude patterns. A repo whose remotes can't be
+// read is added to RepoMap.Errors instead
+func (s *Scanner) mapGitDir(ctx context.Context, path, given string, forced bool, repoMap *RepoMap) {
+	name := repoNameFromGitDir(path)
+	canonical := CanonicalPath(path)
+	for _, repo := range repoMap.Repos {
+		if repo.CanonicalPath == canonical {
+			return
+		}
+	}
+
+	reason := optOutReason(path)
+	if len(reason) == 0 && !forced {
+		if pattern := s.excludedBy(path); len(pattern) > 0 {
+			reason = fmt.Sprintf("matches exclude pattern %s", pattern)
+		}
+	}
+	if len(reason) > 0 {
+		repoMap.Skipped = append(repoMap.Skipped, SkippedRepository{Name: name, Path: path, Reason: reason})
+		return
+	}
+
+	mappedRemotes, err := s.opts.Backend.Remotes(ctx, path)
+	if err != nil {
+		repoMap.Errors = append(repoMap.Errors, err)
+		return
+	}
+
+	if given == path {
+		given = ""
+	}
+	currentRepo := LocalRepository{
+		Name:          name,
+		Path:          path,
+		CanonicalPath: canonical,
+		GivenPath:     given,
+		RemoteSlug:    remoteSlug(mappedRemotes),
+		Remotes:       mappedRemotes,
+	}
+	repoMap.Repos = append(repoMap.Repos, currentRepo)
+}
+
+// Add each repo in a list of paths to the RepoMap without searching. Paths
+// may be a working directory, a .git directory or a bare repo
+func (s *Scanner) mapRepositoryList(ctx context.Context, paths []string, forced bool, repoMap *RepoMap) {
+	for _, listed := range paths {
+		repoPath, err := NormalizePath(listed)
+		if err != nil {
+			repoMap.Errors = append(repoMap.Errors, err)
+			continue
+		}
+		gitDir, given := filepath.Join(repoPath, DotGit), filepath.Join(listed, DotGit)
+		if filepath.Base(repoPath) == DotGit || isBareRepository(repoPath) {
+			gitDir, given = repoPath, listed
+		} else if info, err := os.Stat(gitDir); err == nil && !info.IsDir() {
+			if gitDir, err = resolveGitFile(gitDir); err != nil {
+				repoMap.Errors = append(repoMap.Errors, err)
+				continue
+			}
+			given = gitDir
+		} else if found := FindGitDir(repoPath); found != gitDir {
+			repoMap.Errors = append(repoMap.Errors, fmt.Errorf("%s is not a git repository", repoPath))
+			continue
+		}
+		s.mapGitDir(ctx, gitDir, given, forced, repoMap)
+	}
+}
+
+// Returns the exclude pattern matching a .git path, or "" if none match
+func (s *Scanner) excludedBy(path string) string {
+	repo := LocalRepository{Name: repoNameFromGitDir(path), Path: path}
+	for _, pattern := range s.opts.Exclude {
+		if RepoMatches(repo, pattern) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Returns why a repo has opted out of migration, or "" if it hasn't. A repo
+// opts out with grout.skip=true in its git config or a .grout-ignore file in
+// its working directory (or git directory, for bare repos). The first line
+// of .grout-ignore, if any, is used as the reason
+func optOutReason(gitDir string) string {
+	cfg, err := ReadGitConfigFile(filepath.Join(gitDir, "config"))
+	if err == nil && IsGitTrue(cfg.Section(ConfigSection).Option("skip")) {
+		return fmt.Sprintf("%s.skip is set in git config", ConfigSection)
+	}
+
+	for _, dir := range []string{filepath.Dir(gitDir), gitDir} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, IgnoreFile))
+		if err != nil {
+			continue
+		}
+		reason := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+		if len(reason) == 0 {
+			return fmt.Sprintf("%s file found", IgnoreFile)
+		}
+		return fmt.Sprintf("%s: %s", IgnoreFile, reason)
+	}
+	return ""
+}
+
+// Reports whether a directory is a bare repo, named like name.git and
+// holding the repo's HEAD, config and objects directly
+func isBareRepository(path string) bool {
+	if !strings.HasSuffix(path, DotGit) || filepath.Base(path) == DotGit {
+		return false
+	}
+	for _, entry := range []string{"HEAD", "config", "objects"} {
+		if _, err := os.Stat(filepath.Join(path, entry)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Name a repo after the directory holding its .git directory, or after a
+// bare repo's own directory without the .git suffix
+func repoNameFromGitDir(path string) string {
+	if filepath.Base(path) == DotGit {
+		return filepath.Base(filepath.Dir(path))
+	}
+	return strings.TrimSuffix(filepath.Base(path), DotGit)
+}
+
+// The owner/repo a repo's remotes point at, taken from origin if it has
+// one, otherwise the first remote with a url grout can parse
+func remoteSlug(remotes []Remote) string {
+	slug := ""
+	for _, remote := range remotes {
+		for _, url := range remote.URLs {
+			split, ok := ParseURL(url)
+			if !ok {
+				continue
+			}
+			found := split.Org + "/" + strings.TrimSuffix(split.Repo, DotGit)
+			if remote.Name == "origin" {
+				return found
+			}
+			if len(slug) == 0 {
+				slug = found
+			}
+			break
+		}
+	}
+	return slug
+}
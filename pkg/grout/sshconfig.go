@@ -0,0 +1,224 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// How deep Include directives may nest, as in ssh
+const maxSSHIncludeDepth = 16
+
+// The ssh options grout reads from an ssh config: enough to find the host
+// an alias stands for
+type SSHConfig struct {
+	entries []sshConfigEntry
+	// Where relative Include paths are found
+	dir string
+}
+
+// A keyword and value, with the Host patterns it applies under. Entries
+// under a Match block have no patterns and never apply
+type sshConfigEntry struct {
+	patterns []string
+	key      string
+	value    string
+}
+
+// What an ssh host alias resolves to
+type SSHAlias struct {
+	Alias    string `json:"alias"`
+	HostName string `json:"hostname"`
+	User     string `json:"user,omitempty"`
+	Port     string `json:"port,omitempty"`
+}
+
+func (a SSHAlias) String() string {
+	target := a.HostName
+	if len(a.User) > 0 {
+		target = a.User + "@" + target
+	}
+	if len(a.Port) > 0 && a.Port != defaultPorts[ProtocolSSH] {
+		target += ":" + a.Port
+	}
+	return a.Alias + " -> " + target
+}
+
+// The user's ssh config, ~/.ssh/config
+func DefaultSSHConfigPath() (string, error) {
+	return homedir.Expand(filepath.Join("~", ".ssh", "config"))
+}
+
+// Read an ssh config file and the files it includes. A file that doesn't
+// exist reads as an empty config
+func ReadSSHConfig(filename string) (*SSHConfig, error) {
+	cfg := &SSHConfig{dir: filepath.Dir(filename)}
+	if err := cfg.read(filename, []string{"*"}, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *SSHConfig) read(filename string, patterns []string, depth int) error {
+	if depth > maxSSHIncludeDepth {
+		return fmt.Errorf("%s: too many nested includes", filename)
+	}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		key, args := parseSSHConfigLine(scanner.Text())
+		switch key {
+		case "":
+			continue
+		case "host":
+			patterns = args
+		case "match":
+			patterns = nil
+		case "include":
+			for _, arg := range args {
+				if err := c.include(arg, patterns, depth); err != nil {
+					return fmt.Errorf("%s:%d: %w", filename, line, err)
+				}
+			}
+		default:
+			if len(args) > 0 {
+				c.entries = append(c.entries, sshConfigEntry{patterns: patterns, key: key, value: args[0]})
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Read the files an Include names. Relative paths are in the directory of
+// the config file that was read, ~/.ssh for the user's config, and each
+// may be a glob
+func (c *SSHConfig) include(path string, patterns []string, depth int) error {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.dir, path)
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		if err := c.read(match, patterns, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Split a config line into its lowercased keyword and arguments. Keywords
+// may be followed by spaces or an =, and arguments may be quoted
+func parseSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for len(rest) > 0 {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.Index(rest[1:], `"`)
+			if closing < 0 {
+				arg, rest = rest[1:], ""
+			} else {
+				arg, rest = rest[1:closing+1], rest[closing+2:]
+			}
+		} else if space := strings.IndexAny(rest, " \t"); space >= 0 {
+			arg, rest = rest[:space], rest[space:]
+		} else {
+			arg, rest = rest, ""
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return key, args
+}
+
+// Work out the host, user and port ssh would connect to for a host. As in
+// ssh, the first value found for each keyword wins. Returns false if the
+// config sets no HostName for it, so it isn't an alias
+func (c *SSHConfig) Resolve(host string) (SSHAlias, bool) {
+	alias := SSHAlias{Alias: host}
+	if c == nil {
+		return alias, false
+	}
+	for _, entry := range c.entries {
+		if !matchSSHPatterns(entry.patterns, host) {
+			continue
+		}
+		switch entry.key {
+		case "hostname":
+			if len(alias.HostName) == 0 {
+				alias.HostName = strings.NewReplacer("%h", host, "%%", "%").Replace(entry.value)
+			}
+		case "user":
+			if len(alias.User) == 0 {
+				alias.User = entry.value
+			}
+		case "port":
+			if len(alias.Port) == 0 {
+				alias.Port = entry.value
+			}
+		}
+	}
+	if len(alias.HostName) == 0 || strings.EqualFold(alias.HostName, host) {
+		alias.HostName = host
+		return alias, false
+	}
+	return alias, true
+}
+
+// Reports whether a host matches a Host line's patterns: any one pattern
+// matches and no negated pattern does
+func matchSSHPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := filepath.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), strings.ToLower(host))
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
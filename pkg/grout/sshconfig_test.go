@@ -0,0 +1,157 @@
+package grout
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSSHConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"config": `# work and personal github accounts
+Host github-work
+    HostName github.com
+    IdentityFile ~/.ssh/id_work
+
+Host=gitlab-*  !gitlab-old
+	HostName "gitlab.example.com"
+	Port 2222
+
+Include conf.d/*.conf
+
+Match host github.com
+    User nobody
+
+Host *
+    User git
+    HostName %h.internal
+`,
+		"conf.d/legacy.conf": `Host legacy
+    HostName GitHub.com
+    User deploy
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "config")
+}
+
+func TestSSHConfigResolve(t *testing.T) {
+	cfg, err := ReadSSHConfig(writeSSHConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]SSHAlias{
+		"github-work": {Alias: "github-work", HostName: "github.com", User: "git"},
+		"gitlab-ci":   {Alias: "gitlab-ci", HostName: "gitlab.example.com", User: "git", Port: "2222"},
+		"legacy":      {Alias: "legacy", HostName: "GitHub.com", User: "deploy"},
+		"gitlab-old":  {Alias: "gitlab-old", HostName: "gitlab-old.internal", User: "git"},
+	}
+	for host, want := range tests {
+		if got, ok := cfg.Resolve(host); !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("Resolve(%q) = %+v, %v, want %+v", host, got, ok, want)
+		}
+	}
+
+	cfg, err = ReadSSHConfig(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := cfg.Resolve("github.com"); ok || got.HostName != "github.com" {
+		t.Errorf("Resolve() with no config = %+v, %v, want the host unresolved", got, ok)
+	}
+}
+
+func TestSSHConfigIncludeLoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("Include config\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSSHConfig(path); err == nil {
+		t.Error("ReadSSHConfig() of a config including itself should fail")
+	}
+}
+
+func TestRewriteURLsSSHAlias(t *testing.T) {
+	cfg, err := ReadSSHConfig(writeSSHConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := []string{"github-work:OldUsername/repo.git", "deploy@legacy:OldUsername/repo", "gitlab-ci:OldUsername/repo"}
+	tests := []struct {
+		name    string
+		opts    PlanOptions
+		want    []string
+		changes int
+	}{
+		{
+			name:    "keep alias on the same host",
+			opts:    PlanOptions{FindURL: "github.com", FindOrg: "oldusername", SetOrg: "NewUsername", SSHConfig: cfg},
+			want:    []string{"github-work:NewUsername/repo.git", "deploy@legacy:NewUsername/repo", "gitlab-ci:OldUsername/repo"},
+			changes: 2,
+		},
+		{
+			name:    "replace alias",
+			opts:    PlanOptions{FindURL: "gitlab.example.com", SSHConfig: cfg, ReplaceSSHAliases: true},
+			want:    []string{"github-work:OldUsername/repo.git", "deploy@legacy:OldUsername/repo", "ssh://git@gitlab.example.com:2222/OldUsername/repo"},
+			changes: 1,
+		},
+		{
+			name:    "new host",
+			opts:    PlanOptions{FindURL: "github.com", SetURL: "gitlab.com", SSHConfig: cfg},
+			want:    []string{"git@gitlab.com:OldUsername/repo.git", "deploy@gitlab.com:OldUsername/repo", "gitlab-ci:OldUsername/repo"},
+			changes: 2,
+		},
+		{
+			name: "no ssh config",
+			opts: PlanOptions{FindURL: "github.com", SetURL: "gitlab.com"},
+			want: urls,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newURLs, count := NewPlanner(tt.opts).RewriteURLs(urls)
+			if !reflect.DeepEqual(newURLs, tt.want) || count != tt.changes {
+				t.Errorf("RewriteURLs() = %v, %d, want %v, %d", newURLs, count, tt.want, tt.changes)
+			}
+		})
+	}
+}
+
+func TestPlanRepoRecordsSSHAlias(t *testing.T) {
+	cfg, err := ReadSSHConfig(writeSSHConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	planner := NewPlanner(PlanOptions{FindURL: "github.com", SetURL: "gitlab.com", SSHConfig: cfg})
+	plan := planner.PlanRepo(LocalRepository{Name: "repo", Remotes: []Remote{{Name: "origin", URLs: []string{"github-work:org/repo.git"}}}})
+	if len(plan.Changes) != 1 || plan.Changes[0].SSHAlias == nil {
+		t.Fatalf("PlanRepo() changes = %+v, want one change with an ssh alias", plan.Changes)
+	}
+	if got, want := plan.Changes[0].SSHAlias.String(), "github-work -> git@github.com"; got != want {
+		t.Errorf("SSHAlias = %q, want %q", got, want)
+	}
+}
+
+func TestValidRemoteURL(t *testing.T) {
+	for _, url := range []string{"git@github.com:org/repo.git", "github-work:org/repo.git", "ssh://git@gitlab.example.com:2222/org/repo"} {
+		if !ValidRemoteURL(url) {
+			t.Errorf("ValidRemoteURL(%q) = false, want true", url)
+		}
+	}
+	for _, url := range []string{"/srv/git/repo.git", "github-work:", "https://github.com"} {
+		if ValidRemoteURL(url) {
+			t.Errorf("ValidRemoteURL(%q) = true, want false", url)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const stashRef = "refs/stash"
+
+// Local work that would be left behind if a repo's remotes were switched
+// to a new host without pushing it first
+type UnpushedWork struct {
+	Branches []UnpushedBranch `json:"branches,omitempty"`
+	Stashes  int              `json:"stashes,omitempty"`
+}
+
+// A local branch with commits the old remote doesn't have. Untracked
+// branches have no remote-tracking branch at all, so Ahead isn't counted
+type UnpushedBranch struct {
+	Name      string `json:"name"`
+	Ahead     int    `json:"ahead,omitempty"`
+	Untracked bool   `json:"untracked,omitempty"`
+}
+
+func (w UnpushedWork) Empty() bool {
+	return len(w.Branches) == 0 && w.Stashes == 0
+}
+
+func (w UnpushedWork) String() string {
+	var parts []string
+	for _, branch := range w.Branches {
+		if branch.Untracked {
+			parts = append(parts, fmt.Sprintf("branch %s was never pushed", branch.Name))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d unpushed commit(s) on %s", branch.Ahead, branch.Name))
+		}
+	}
+	if w.Stashes > 0 {
+		parts = append(parts, fmt.Sprintf("%d stash(es)", w.Stashes))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// A local branch and the remote-tracking ref it is set to track, if any
+type localBranch struct {
+	name     string
+	hash     string
+	upstream string
+}
+
+// Compare each local branch with the remotes' tracking refs. A branch is
+// checked against its upstream when that is on one of the remotes, and
+// otherwise against a branch of the same name on any of them. Branches
+// tracking another remote aren't affected by the change and are left out
+func unpushedBranches(branches []localBranch, tracking map[string]string, remotes []string,
+	ahead func(branch, tracking string) (int, error)) ([]UnpushedBranch, error) {
+	var unpushed []UnpushedBranch
+	for _, branch := range branches {
+		trackingRef := ""
+		if _, ok := tracking[branch.upstream]; ok {
+			if !onRemotes(branch.upstream, remotes) {
+				continue
+			}
+			trackingRef = branch.upstream
+		}
+		for _, remote := range remotes {
+			if _, ok := tracking["refs/remotes/"+remote+"/"+branch.name]; ok && len(trackingRef) == 0 {
+				trackingRef = "refs/remotes/" + remote + "/" + branch.name
+			}
+		}
+
+		if len(trackingRef) == 0 {
+			unpushed = append(unpushed, UnpushedBranch{Name: branch.name, Untracked: true})
+			continue
+		}
+		if tracking[trackingRef] == branch.hash {
+			continue
+		}
+		count, err := ahead(branch.name, trackingRef)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			unpushed = append(unpushed, UnpushedBranch{Name: branch.name, Ahead: count})
+		}
+	}
+	sort.Slice(unpushed, func(i, j int) bool { return unpushed[i].Name < unpushed[j].Name })
+	return unpushed, nil
+}
+
+// Reports whether a remote-tracking ref belongs to one of the remotes
+func onRemotes(ref string, remotes []string) bool {
+	for _, remote := range remotes {
+		if strings.HasPrefix(ref, "refs/remotes/"+remote+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Bare repos have no working state of their own to lose, so they are
+// never reported
+func isBareGitDir(gitDir string) bool {
+	return filepath.Base(gitDir) != DotGit
+}
+
+func (GoGitBackend) UnpushedWork(ctx context.Context, gitDir string, remotes []string) (UnpushedWork, error) {
+	var work UnpushedWork
+	if err := ctx.Err(); err != nil || isBareGitDir(gitDir) {
+		return work, err
+	}
+	r, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return work, fmt.Errorf("error opening repo %s: %w", gitDir, err)
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return work, fmt.Errorf("error reading config of %s: %w", gitDir, err)
+	}
+	iter, err := r.References()
+	if err != nil {
+		return work, fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+
+	var branches []localBranch
+	tracking := map[string]string{}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		name := ref.Name()
+		switch {
+		case name.IsBranch():
+			branch := localBranch{name: name.Short(), hash: ref.Hash().String()}
+			if branchCfg, ok := cfg.Branches[branch.name]; ok && len(branchCfg.Remote) > 0 && branchCfg.Merge.IsBranch() {
+				branch.upstream = "refs/remotes/" + branchCfg.Remote + "/" + branchCfg.Merge.Short()
+			}
+			branches = append(branches, branch)
+		case name.IsRemote():
+			tracking[name.String()] = ref.Hash().String()
+		case name.String() == stashRef:
+			work.Stashes = countStashes(gitDir)
+		}
+		return nil
+	})
+	if err != nil {
+		return work, fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+
+	work.Branches, err = unpushedBranches(branches, tracking, remotes, func(branch, trackingRef string) (int, error) {
+		return countAhead(r, plumbing.NewBranchReferenceName(branch), plumbing.ReferenceName(trackingRef))
+	})
+	if err != nil {
+		return work, fmt.Errorf("error comparing branches of %s: %w", gitDir, err)
+	}
+	return work, nil
+}
+
+// Count the commits reachable from a branch but not from a tracking ref,
+// as git rev-list tracking..branch does. Both refs are walked together,
+// newest commit first, marking everything reachable from the tracking ref
+// as pushed. The walk stops once only pushed commits are left to visit, so
+// the history the two refs share isn't read
+func countAhead(r *git.Repository, branch, tracking plumbing.ReferenceName) (int, error) {
+	trackingRef, err := r.Reference(tracking, true)
+	if err != nil {
+		return 0, err
+	}
+	branchRef, err := r.Reference(branch, true)
+	if err != nil {
+		return 0, err
+	}
+
+	type walkEntry struct {
+		commit *object.Commit
+		pushed bool
+	}
+	var queue []walkEntry
+	pushed := map[plumbing.Hash]bool{}
+	visited := map[plumbing.Hash]bool{}
+	add := func(hash plumbing.Hash, isPushed bool) error {
+		if pushed[hash] || (!isPushed && visited[hash]) {
+			return nil
+		}
+		commit, err := r.CommitObject(hash)
+		if err != nil {
+			return err
+		}
+		if isPushed {
+			pushed[hash] = true
+		} else {
+			visited[hash] = true
+		}
+		queue = append(queue, walkEntry{commit: commit, pushed: isPushed})
+		return nil
+	}
+	unpushedLeft := func() bool {
+		for _, entry := range queue {
+			if !entry.pushed && !pushed[entry.commit.Hash] {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := add(trackingRef.Hash(), true); err != nil {
+		return 0, err
+	}
+	if err := add(branchRef.Hash(), false); err != nil {
+		return 0, err
+	}
+	var candidates []plumbing.Hash
+	for unpushedLeft() {
+		newest := 0
+		for i, entry := range queue {
+			if entry.commit.Committer.When.After(queue[newest].commit.Committer.When) {
+				newest = i
+			}
+		}
+		entry := queue[newest]
+		queue = append(queue[:newest], queue[newest+1:]...)
+		if !entry.pushed {
+			if pushed[entry.commit.Hash] {
+				continue
+			}
+			candidates = append(candidates, entry.commit.Hash)
+		}
+		for _, parent := range entry.commit.ParentHashes {
+			if err := add(parent, entry.pushed); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// a commit may be found to be pushed after it was first visited
+	count := 0
+	for _, hash := range candidates {
+		if !pushed[hash] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// go-git doesn't read reflogs, so the stash entries are counted from the
+// stash's reflog file directly
+func countStashes(gitDir string) int {
+	file, err := os.Open(filepath.Join(gitDir, "logs", stashRef))
+	if err != nil {
+		return 1
+	}
+	defer file.Close()
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) > 0 {
+			count++
+		}
+	}
+	if count == 0 {
+		return 1
+	}
+	return count
+}
+
+func (b ExecBackend) UnpushedWork(ctx context.Context, gitDir string, remotes []string) (UnpushedWork, error) {
+	var work UnpushedWork
+	if isBareGitDir(gitDir) {
+		return work, ctx.Err()
+	}
+	out, err := b.git(ctx, gitDir, "for-each-ref", "--format=%(refname)%00%(objectname)%00%(upstream)",
+		"refs/heads/", "refs/remotes/", stashRef)
+	if err != nil {
+		return work, fmt.Errorf("error reading refs of %s: %w", gitDir, err)
+	}
+
+	var branches []localBranch
+	tracking := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 3 {
+			continue
+		}
+		name, hash, upstream := fields[0], fields[1], fields[2]
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			branches = append(branches, localBranch{name: strings.TrimPrefix(name, "refs/heads/"), hash: hash, upstream: upstream})
+		case strings.HasPrefix(name, "refs/remotes/"):
+			tracking[name] = hash
+		case name == stashRef:
+			count, err := b.git(ctx, gitDir, "rev-list", "--walk-reflogs", "--count", stashRef)
+			if err != nil {
+				return work, fmt.Errorf("error reading stashes of %s: %w", gitDir, err)
+			}
+			work.Stashes, _ = strconv.Atoi(strings.TrimSpace(count))
+		}
+	}
+
+	work.Branches, err = unpushedBranches(branches, tracking, remotes, func(branch, trackingRef string) (int, error) {
+		count, err := b.git(ctx, gitDir, "rev-list", "--count", trackingRef+"..refs/heads/"+branch)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(strings.TrimSpace(count))
+	})
+	if err != nil {
+		return work, fmt.Errorf("error comparing branches of %s: %w", gitDir, err)
+	}
+	return work, nil
+}
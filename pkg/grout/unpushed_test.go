@@ -0,0 +1,119 @@
+package grout
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// A clone of the old remote with a commit on main that wasn't pushed, a
+// branch that was never pushed, a branch pushed only to another remote and
+// a stash
+func unpushedFixture(t *testing.T) (clean, dirty string) {
+	root, clean := verifyFixture(t)
+	work := filepath.Join(root, "dirty")
+	runGit(t, root, "clone", "-q", filepath.Join(root, "old.git"), work)
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "local")
+	runGit(t, work, "branch", "wip")
+
+	runGit(t, root, "init", "-q", "--bare", "fork.git")
+	runGit(t, work, "remote", "add", "fork", filepath.Join(root, "fork.git"))
+	runGit(t, work, "checkout", "-q", "-b", "forked")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "forked")
+	runGit(t, work, "push", "-q", "-u", "fork", "forked")
+	runGit(t, work, "checkout", "-q", "main")
+
+	if err := ioutil.WriteFile(filepath.Join(work, "notes.txt"), []byte("draft\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "notes.txt")
+	runGit(t, work, "stash", "-q")
+	return clean, filepath.Join(work, DotGit)
+}
+
+func TestUnpushedWork(t *testing.T) {
+	for name, backend := range backendsUnderTest(t) {
+		t.Run(name, func(t *testing.T) {
+			clean, dirty := unpushedFixture(t)
+
+			work, err := backend.UnpushedWork(context.Background(), clean, []string{"origin"})
+			if err != nil || !work.Empty() {
+				t.Errorf("fresh clone: UnpushedWork() = %+v, %v, want none", work, err)
+			}
+
+			work, err = backend.UnpushedWork(context.Background(), dirty, []string{"origin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := UnpushedWork{
+				Branches: []UnpushedBranch{{Name: "main", Ahead: 1}, {Name: "wip", Untracked: true}},
+				Stashes:  1,
+			}
+			if !reflect.DeepEqual(work, want) {
+				t.Errorf("UnpushedWork() = %+v, want %+v", work, want)
+			}
+		})
+	}
+}
+
+// Unpushed commits brought into main by a merge are counted, however far
+// behind the pushed history they start
+func TestUnpushedWorkMerge(t *testing.T) {
+	for name, backend := range backendsUnderTest(t) {
+		t.Run(name, func(t *testing.T) {
+			root, _ := verifyFixture(t)
+			work := filepath.Join(root, "merged")
+			runGit(t, root, "clone", "-q", filepath.Join(root, "old.git"), work)
+			runGit(t, work, "checkout", "-q", "-b", "side")
+			runGit(t, work, "commit", "-q", "--allow-empty", "-m", "side one")
+			runGit(t, work, "commit", "-q", "--allow-empty", "-m", "side two")
+			runGit(t, work, "checkout", "-q", "main")
+			runGit(t, work, "commit", "-q", "--allow-empty", "-m", "local")
+			runGit(t, work, "merge", "-q", "--no-ff", "-m", "merge side", "side")
+			runGit(t, work, "branch", "-q", "-D", "side")
+
+			got, err := backend.UnpushedWork(context.Background(), filepath.Join(work, DotGit), []string{"origin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := UnpushedWork{Branches: []UnpushedBranch{{Name: "main", Ahead: 4}}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("UnpushedWork() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestPlanExcludesUnpushed(t *testing.T) {
+	clean, dirty := unpushedFixture(t)
+	remotes := []Remote{{Name: "origin", URLs: []string{remoteURL1}}}
+	repoMap := RepoMap{Repos: []LocalRepository{
+		{Name: "clean", Path: clean, Remotes: remotes},
+		{Name: "dirty", Path: dirty, Remotes: remotes},
+	}}
+
+	set, err := testPlanner(PlanOptions{ExcludeUnpushed: true}).Plan(context.Background(), repoMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Errors) > 0 {
+		t.Fatalf("Plan() errors = %v", set.Errors)
+	}
+	if set.Plans[0].Excluded || set.Plans[0].UnpushedWork != nil {
+		t.Errorf("clean repo: plan = %+v, want it included", set.Plans[0])
+	}
+	if !set.Plans[1].Excluded || set.Plans[1].UnpushedWork == nil {
+		t.Errorf("dirty repo: plan = %+v, want it excluded with its unpushed work", set.Plans[1])
+	}
+	if set.Count != 1 {
+		t.Errorf("Count = %d, want 1", set.Count)
+	}
+
+	// without the check nothing is inspected
+	set, _ = testPlanner(PlanOptions{}).Plan(context.Background(), repoMap)
+	if set.Plans[1].UnpushedWork != nil || set.Plans[1].Excluded {
+		t.Errorf("unchecked plan = %+v", set.Plans[1])
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2021 Joshua Rodstein joshuarodstein@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package grout
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// The outcome of verifying a new url
+const (
+	VerifyOK          = "ok"
+	VerifyMismatch    = "ref-mismatch"
+	VerifyMissing     = "missing"
+	VerifyAuthFailed  = "auth-failed"
+	VerifyUnreachable = "unreachable"
+)
+
+// How a Verifier checks the new urls in a ChangeSet
+type VerifyOptions struct {
+	// Called after each url is verified
+	Progress func(result URLVerification)
+	// Lists the new urls and reads local refs. Defaults to go-git
+	Backend Backend
+}
+
+// A Verifier checks that each new url leads to a repo holding the branches
+// last fetched from the old url
+type Verifier struct {
+	opts VerifyOptions
+}
+
+// The result of checking one new url of a remote. Only the repo's name and
+// path are kept, so its other urls and their credentials stay out of the
+// results
+type URLVerification struct {
+	Repo   string `json:"repo"`
+	Path   string `json:"path"`
+	Remote string `json:"remote"`
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	// Why the url couldn't be listed, for missing, auth-failed and
+	// unreachable urls
+	Error string `json:"error,omitempty"`
+	// Remote-tracking branches the new url doesn't agree with
+	Mismatches []RefMismatch `json:"mismatches,omitempty"`
+}
+
+// A remote-tracking branch that differs from the branch the new url
+// advertises. Remote is empty if the new url doesn't have the branch
+type RefMismatch struct {
+	Branch string `json:"branch"`
+	Local  string `json:"local"`
+	Remote string `json:"remote,omitempty"`
+}
+
+func NewVerifier(opts VerifyOptions) *Verifier {
+	opts.Backend = backendOrDefault(opts.Backend)
+	return &Verifier{opts: opts}
+}
+
+// Verify the new urls of every plan in the ChangeSet, leaving out excluded
+// repos and changes. The returned error is only set if the context is done
+func (v *Verifier) Verify(ctx context.Context, set ChangeSet) ([]URLVerification, error) {
+	var results []URLVerification
+	for _, plan := range set.Plans {
+		if plan.Excluded {
+			continue
+		}
+		planResults, err := v.VerifyPlan(ctx, plan)
+		results = append(results, planResults...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Verify the new urls planned for a single repo
+func (v *Verifier) VerifyPlan(ctx context.Context, plan RepoPlan) ([]URLVerification, error) {
+	var results []URLVerification
+	plan, unredactErr := unredactPlan(ctx, v.opts.Backend, plan)
+	for _, change := range plan.Changes {
+		if change.Excluded {
+			continue
+		}
+		for _, url := range change.NewURLs {
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			var result URLVerification
+			if unredactErr != nil && isRedacted(url) {
+				// without its credentials the url can only fail
+				result = URLVerification{Repo: plan.Repo.Name, Path: plan.Repo.Path, Remote: change.Name, URL: url,
+					Status: VerifyAuthFailed, Error: unredactErr.Error()}
+			} else {
+				result = v.verifyURL(ctx, plan.Repo, change.Name, url)
+			}
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			if v.opts.Progress != nil {
+				v.opts.Progress(result)
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// List a new url and compare what it advertises with the remote-tracking
+// branches fetched from the remote before it was changed
+func (v *Verifier) verifyURL(ctx context.Context, repo LocalRepository, remote, url string) URLVerification {
+	result := URLVerification{Repo: repo.Name, Path: repo.Path, Remote: remote, URL: RedactURL(url), Status: VerifyOK}
+	advertised, err := v.opts.Backend.LsRemote(ctx, repo.Path, url)
+	if err != nil {
+		result.Error = RedactURL(err.Error())
+		switch {
+		case errors.Is(err, ErrRemoteRepoNotFound):
+			result.Status = VerifyMissing
+		case errors.Is(err, ErrRemoteAuthFailed):
+			result.Status = VerifyAuthFailed
+		default:
+			result.Status = VerifyUnreachable
+		}
+		return result
+	}
+
+	tracking, err := v.opts.Backend.TrackingRefs(ctx, repo.Path, remote)
+	if err != nil {
+		result.Status = VerifyUnreachable
+		result.Error = err.Error()
+		return result
+	}
+	result.Mismatches = compareRefs(tracking, advertised)
+	if len(result.Mismatches) > 0 {
+		result.Status = VerifyMismatch
+	}
+	return result
+}
+
+// The tracking branches that are missing from, or point somewhere else
+// than, the branches a url advertises, sorted by branch
+func compareRefs(tracking, advertised map[string]string) []RefMismatch {
+	var mismatches []RefMismatch
+	for branch, local := range tracking {
+		remote := advertised["refs/heads/"+branch]
+		if remote != local {
+			mismatches = append(mismatches, RefMismatch{Branch: branch, Local: local, Remote: remote})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Branch < mismatches[j].Branch })
+	return mismatches
+}